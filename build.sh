@@ -1,9 +1,16 @@
 #!/bin/bash
 
-go build -o out/syncer cmd/syncer/syncer.go
+go build -o out/syncer ./cmd/syncer
 sudo rm /usr/bin/syncer
 sudo cp out/syncer /usr/bin/syncer
 
-go build -o out/syncerd cmd/daemon/syncerd.go
+go build -o out/syncerd ./cmd/daemon
 sudo rm /usr/bin/syncerd
 sudo cp out/syncerd /usr/bin/syncerd
+
+# The daemon runs as a systemd user service, see "syncer help service"
+if systemctl --user is-enabled --quiet syncerd 2> /dev/null; then
+  systemctl --user restart syncerd
+else
+  echo "Run \"syncer service install\" to start syncerd when you log in"
+fi
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"syncer/internal"
+)
+
+// The daemon answers the requests on the control socket, see
+// internal.ControlHandler
+
+func (d *daemon) Status() internal.DaemonStatus {
+  files, err := internal.GetFiles()
+  if err != nil {
+    log.Printf("Unable to get the watched files\n%v\n", err)
+  }
+
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  return internal.DaemonStatus{
+    Pid: os.Getpid(),
+    Started: d.started,
+    LastSync: d.lastSync,
+    Syncing: d.syncing,
+    Paused: d.paused,
+    Files: len(files),
+  }
+}
+
+func (d *daemon) SyncNow() {
+  select {
+  case d.syncNow <- struct{}{}:
+  default:
+    // A sync is requested already
+  }
+}
+
+// Sync pulls or pushes the files right away, even when the daemon is paused
+func (d *daemon) Sync(request internal.SyncRequest) []internal.SyncResponse {
+  responses := make([]internal.SyncResponse, 0)
+
+  // Shutting down waits for the work that was added before it started
+  d.mu.Lock()
+  if d.ctx.Err() != nil {
+    d.mu.Unlock()
+    for _, id := range request.Ids {
+      responses = append(responses, internal.SyncResponse{Id: id, Outcome: internal.Failed, Error: "the daemon is shutting down"})
+    }
+    return responses
+  }
+  d.work.Add(1)
+  d.mu.Unlock()
+  defer d.work.Done()
+
+  files, err := internal.GetFiles()
+  if err != nil {
+    for _, id := range request.Ids {
+      responses = append(responses, internal.SyncResponse{Id: id, Outcome: internal.Failed, Error: err.Error()})
+    }
+    return responses
+  }
+
+  byId := make(map[int]internal.File)
+  for _, f := range files {
+    byId[f.Id] = f
+  }
+
+  options := internal.SyncOptions{Push: request.Push, Force: request.Force}
+  for _, id := range request.Ids {
+    f, ok := byId[id]
+    if !ok {
+      responses = append(responses, internal.SyncResponse{Id: id, Outcome: internal.Failed, Error: fmt.Sprintf("file %v is not being watched", id)})
+      continue
+    }
+
+    outcome, err := d.syncRequested(&f, options)
+    response := internal.SyncResponse{Id: id, Outcome: outcome}
+    if err != nil {
+      response.Error = err.Error()
+    }
+    responses = append(responses, response)
+  }
+
+  return responses
+}
+
+func (d *daemon) syncRequested(f *internal.File, options internal.SyncOptions) (internal.Outcome, error) {
+  repo := f.Account.Repository()
+  if repo == nil {
+    return internal.Failed, fmt.Errorf("%v is not supported", f.Account.Vendor)
+  }
+
+  unlock := locks.lock(f.Id)
+  defer unlock()
+
+  if !options.Push && d.watcher != nil {
+    d.watcher.Mute(f.LocalPath)
+    defer d.watcher.Unmute(f.LocalPath)
+  }
+
+  return internal.SyncFile(repo, f, options)
+}
+
+func (d *daemon) Pause() {
+  d.mu.Lock()
+  d.paused = true
+  d.mu.Unlock()
+
+  log.Println("Paused syncing")
+  d.notifyStatus()
+}
+
+func (d *daemon) Resume() {
+  d.mu.Lock()
+  d.paused = false
+  d.mu.Unlock()
+
+  log.Println("Resumed syncing")
+  d.notifyStatus()
+  d.SyncNow()
+}
+
+// Reload picks up the changes to the watched files, the credentials and
+// the settings. The notifications are only set up again by a restart.
+func (d *daemon) Reload() error {
+  internal.ResetRepositories()
+  if d.watcher != nil {
+    watchFiles(d.watcher)
+  }
+
+  webhook, listen, err := notificationSettings()
+  if err != nil {
+    return err
+  }
+  d.schedule.setInterval(syncInterval())
+
+  if webhook != d.webhook || listen != d.listen {
+    log.Println("The notification settings changed, restart the daemon to apply them")
+  }
+
+  log.Println("Reloaded the watched files, credentials and settings")
+  d.SyncNow()
+  return nil
+}
+
+func (d *daemon) Shutdown() {
+  log.Println("Shutting down")
+  d.notify("STOPPING=1")
+
+  d.mu.Lock()
+  d.cancel()
+  d.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"syncer/internal"
+)
+
+const (
+  // JITTER is the part of the interval a sync is moved back or forth by at
+  // random, so the files of an account aren't all synced at once
+  JITTER = 0.1
+  // MAXBACKOFF is the longest a file that keeps failing waits to be retried
+  MAXBACKOFF = time.Hour
+)
+
+// Clock tells the time and waits for it, the scheduler can be given another
+// one than the system clock to control the time
+type Clock interface {
+  Now() time.Time
+  After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+  return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+  return time.After(d)
+}
+
+// scheduler decides when each file is synced. A file is synced every
+// sync.interval or its own interval, the interval doubles every time a
+// sync of the file fails in a row up to MAXBACKOFF.
+type scheduler struct {
+  clock Clock
+  random *rand.Rand
+
+  mu sync.Mutex
+  // interval is used for the files without their own
+  interval time.Duration
+  next map[int]time.Time
+  failures map[int]int
+}
+
+func newScheduler(clock Clock, random *rand.Rand, interval time.Duration) *scheduler {
+  return &scheduler{
+    clock: clock,
+    random: random,
+    interval: interval,
+    next: make(map[int]time.Time),
+    failures: make(map[int]int),
+  }
+}
+
+// setInterval changes the default interval, the files keep the time of
+// their next sync
+func (s *scheduler) setInterval(interval time.Duration) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.interval = interval
+}
+
+func (s *scheduler) intervalOf(f internal.File) time.Duration {
+  if f.Interval > 0 {
+    return f.Interval
+  }
+  return s.interval
+}
+
+// due returns the files that are to be synced now, or all of them. A file
+// the scheduler doesn't know yet is due right away, so every file is synced
+// when the daemon starts. The files that are returned aren't due again
+// until their interval passed or done is called.
+func (s *scheduler) due(files []internal.File, all bool) []internal.File {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  now := s.clock.Now()
+  known := make(map[int]bool)
+  due := make([]internal.File, 0)
+  for _, f := range files {
+    known[f.Id] = true
+
+    next, ok := s.next[f.Id]
+    if all || !ok || !now.Before(next) {
+      due = append(due, f)
+      s.next[f.Id] = now.Add(s.intervalOf(f))
+    }
+  }
+
+  // The files that are no longer watched
+  for id := range s.next {
+    if !known[id] {
+      delete(s.next, id)
+      delete(s.failures, id)
+    }
+  }
+
+  return due
+}
+
+// done schedules the next sync of the file after it was synced, a failure
+// postpones it further than the last one
+func (s *scheduler) done(f internal.File, failed bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if failed {
+    s.failures[f.Id]++
+  } else {
+    delete(s.failures, f.Id)
+  }
+
+  s.next[f.Id] = s.clock.Now().Add(s.delay(s.intervalOf(f), s.failures[f.Id]))
+}
+
+// delay is the interval doubled for every failure and moved by the jitter
+func (s *scheduler) delay(interval time.Duration, failures int) time.Duration {
+  delay := interval
+  for i := 0; i < failures && delay < MAXBACKOFF; i++ {
+    delay *= 2
+  }
+  if failures > 0 && delay > MAXBACKOFF {
+    delay = max(interval, MAXBACKOFF)
+  }
+
+  jitter := time.Duration((s.random.Float64() * 2 - 1) * JITTER * float64(delay))
+  return delay + jitter
+}
+
+// wait returns how long until the next file is due. It is never longer
+// than the default interval, which picks up the files that were added.
+func (s *scheduler) wait() time.Duration {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  now := s.clock.Now()
+  wait := s.interval
+  for _, next := range s.next {
+    wait = min(wait, next.Sub(now))
+  }
+
+  return max(wait, 0)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"syncer/internal"
+)
+
+// fakeClock only moves when the test advances it
+type fakeClock struct {
+  now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+  return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+  ch := make(chan time.Time, 1)
+  ch <- c.now.Add(d)
+  return ch
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+  c.now = c.now.Add(d)
+}
+
+// fixedSource always returns the same number, 1 << 52 makes Float64 return
+// 0.5 and so the jitter 0
+type fixedSource uint64
+
+func (s fixedSource) Uint64() uint64 {
+  return uint64(s)
+}
+
+func newTestScheduler(interval time.Duration) (*scheduler, *fakeClock) {
+  clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+  return newScheduler(clock, rand.New(fixedSource(1 << 52)), interval), clock
+}
+
+func fileIds(files []internal.File) []int {
+  ids := make([]int, 0)
+  for _, f := range files {
+    ids = append(ids, f.Id)
+  }
+  return ids
+}
+
+func TestSchedulerDue(t *testing.T) {
+  s, clock := newTestScheduler(10 * time.Minute)
+  files := []internal.File{{Id: 1}, {Id: 2, Interval: time.Minute}}
+
+  if got := fileIds(s.due(files, false)); len(got) != 2 {
+    t.Fatalf("due at start = %v, want every file", got)
+  }
+  if got := s.due(files, false); len(got) != 0 {
+    t.Errorf("due right after = %v, want none", fileIds(got))
+  }
+
+  // A file that was synced is due again after its own interval
+  s.done(files[1], false)
+  clock.advance(time.Minute)
+  if got := fileIds(s.due(files, false)); len(got) != 1 || got[0] != 2 {
+    t.Errorf("due after a minute = %v, want [2]", got)
+  }
+
+  s.done(files[0], false)
+  clock.advance(9 * time.Minute)
+  if got := fileIds(s.due(files, false)); len(got) != 1 || got[0] != 2 {
+    t.Errorf("due after 10 minutes = %v, want [2]", got)
+  }
+  clock.advance(time.Minute)
+  if got := fileIds(s.due(files, false)); len(got) != 2 {
+    t.Errorf("due after 11 minutes = %v, want both", got)
+  }
+
+  if got := fileIds(s.due(files, true)); len(got) != 2 {
+    t.Errorf("due with all = %v, want both", got)
+  }
+}
+
+func TestSchedulerWait(t *testing.T) {
+  s, clock := newTestScheduler(10 * time.Minute)
+  files := []internal.File{{Id: 1, Interval: 3 * time.Minute}}
+
+  s.due(files, false)
+  if got := s.wait(); got != 3 * time.Minute {
+    t.Errorf("wait = %v, want 3m", got)
+  }
+
+  clock.advance(5 * time.Minute)
+  if got := s.wait(); got != 0 {
+    t.Errorf("wait of an overdue file = %v, want 0", got)
+  }
+
+  // Files that are no longer watched are forgotten
+  s.due(nil, false)
+  if got := s.wait(); got != 10 * time.Minute {
+    t.Errorf("wait without files = %v, want the interval", got)
+  }
+}
+
+func TestSchedulerJitter(t *testing.T) {
+  clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+  s := newScheduler(clock, rand.New(rand.NewPCG(1, 2)), 10 * time.Minute)
+  f := internal.File{Id: 1}
+
+  low, high := 10 * time.Minute, 10 * time.Minute
+  for i := 0; i < 1000; i++ {
+    s.done(f, false)
+    delay := s.next[f.Id].Sub(clock.Now())
+    if delay < 9 * time.Minute || delay > 11 * time.Minute {
+      t.Fatalf("delay %v is more than 10%% off the interval", delay)
+    }
+    low, high = min(low, delay), max(high, delay)
+  }
+
+  if low > 9 * time.Minute + 30 * time.Second || high < 10 * time.Minute + 30 * time.Second {
+    t.Errorf("delays from %v to %v, want them spread over ±10%%", low, high)
+  }
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+  s, clock := newTestScheduler(10 * time.Minute)
+  f := internal.File{Id: 1}
+
+  want := []time.Duration{20 * time.Minute, 40 * time.Minute, time.Hour, time.Hour, time.Hour}
+  for i, w := range want {
+    s.done(f, true)
+    if got := s.next[f.Id].Sub(clock.Now()); got != w {
+      t.Errorf("delay after %v failures = %v, want %v", i + 1, got, w)
+    }
+  }
+
+  // A success resets the interval
+  s.done(f, false)
+  if got := s.next[f.Id].Sub(clock.Now()); got != 10 * time.Minute {
+    t.Errorf("delay after a success = %v, want 10m", got)
+  }
+  s.done(f, true)
+  if got := s.next[f.Id].Sub(clock.Now()); got != 20 * time.Minute {
+    t.Errorf("delay after a new failure = %v, want 20m", got)
+  }
+}
+
+func TestSchedulerBackoffLongInterval(t *testing.T) {
+  s, clock := newTestScheduler(2 * time.Hour)
+  f := internal.File{Id: 1}
+
+  // An interval that is longer than MAXBACKOFF is never shortened
+  s.done(f, true)
+  if got := s.next[f.Id].Sub(clock.Now()); got != 2 * time.Hour {
+    t.Errorf("delay after a failure = %v, want 2h", got)
+  }
+}
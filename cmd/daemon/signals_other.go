@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// Only unix has signals to reload and sync, the control socket does both
+var (
+  stopSignals = []os.Signal{os.Interrupt}
+  reloadSignals = []os.Signal{}
+  syncSignals = []os.Signal{}
+)
@@ -0,0 +1,16 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// SIGHUP reloads the configuration and SIGUSR1 syncs right away, like
+// "syncer reload" and the SyncNow request on the control socket
+var (
+  stopSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+  reloadSignals = []os.Signal{syscall.SIGHUP}
+  syncSignals = []os.Signal{syscall.SIGUSR1}
+)
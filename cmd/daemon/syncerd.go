@@ -1,52 +1,621 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"syncer/internal"
 )
 
+const (
+  // INTERVAL is used when the sync.interval setting is invalid
+  INTERVAL = time.Second * 30
+  DEBOUNCE = time.Second * 2
+  // Channels are renewed this long before they expire
+  RENEW = time.Minute * 10
+  RETRY = time.Minute
+  // Number of files that are synced at the same time
+  PARALLEL = 4
+  // SHUTDOWN is how long the transfers get to stop when shutting down
+  SHUTDOWN = time.Second * 10
+  // STALLED is how long the daemon may go without progress before the
+  // watchdog pings stop, it allows for a slow transfer
+  STALLED = time.Minute * 10
+)
+
+// locks keeps a file from being synced by more than one goroutine at a time
+var locks = fileLocks{locks: make(map[int]*sync.Mutex)}
+
+type fileLocks struct {
+  mu sync.Mutex
+  locks map[int]*sync.Mutex
+}
+
+// lock blocks until the file is free and returns the function to free it
+func (l *fileLocks) lock(id int) func() {
+  l.mu.Lock()
+  m, ok := l.locks[id]
+  if !ok {
+    m = &sync.Mutex{}
+    l.locks[id] = m
+  }
+  l.mu.Unlock()
+
+  m.Lock()
+  return m.Unlock
+}
+
+// daemon is the state of syncerd, the control socket reads and changes it
+// while the main loop runs
+type daemon struct {
+  ctx context.Context
+  cancel context.CancelFunc
+  watcher *internal.Watcher
+  // syncNow asks the main loop for a sync of all files
+  syncNow chan struct{}
+  schedule *scheduler
+  // work counts the syncs outside of the main loop, they are waited for
+  // when shutting down
+  work sync.WaitGroup
+  // webhook and listen are the notification settings the daemon started
+  // with
+  webhook string
+  listen string
+
+  // mu guards the fields below, and the start of work against shutting
+  // down
+  mu sync.Mutex
+  started time.Time
+  lastSync time.Time
+  syncing bool
+  paused bool
+  // heartbeat is when the main loop or a sync last made progress
+  heartbeat time.Time
+}
+
 func main() {
-  err := internal.Init() 
+  // The journal adds the time to the entries itself
+  if internal.JournalStream() {
+    log.SetFlags(0)
+    journal, err := internal.NewJournal("syncerd")
+    if err != nil {
+      log.Printf("Unable to connect to the journal, logging to stderr\n%v\n", err)
+    } else {
+      defer journal.Close()
+      log.SetOutput(journal)
+    }
+  }
+
+  err := internal.Init()
   if err != nil {
     log.Fatalf("%v", err)
   }
 
+  pidFile, err := internal.LockPidFile()
+  if err != nil {
+    log.Fatalf("Unable to start the daemon\n%v\n", err)
+  }
+  defer pidFile.Close()
+
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  internal.SetContext(ctx)
+
+  d := &daemon{
+    ctx: ctx,
+    cancel: cancel,
+    syncNow: make(chan struct{}, 1),
+    schedule: newScheduler(systemClock{}, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), syncInterval()),
+    started: time.Now(),
+  }
+  d.webhook, d.listen, err = notificationSettings()
+  if err != nil {
+    log.Printf("Unable to get the notification settings\n%v\n", err)
+  }
+
+  listener, err := internal.ListenControl()
+  if err != nil {
+    log.Fatalf("Unable to create the control socket\n%v\n", err)
+  }
+  defer listener.Close()
+  go func() {
+    err := internal.ServeControl(listener, d)
+    if err != nil {
+      log.Printf("Unable to serve the control socket\n%v\n", err)
+    }
+  }()
+
+  d.watcher, err = internal.NewWatcher(DEBOUNCE)
+  if err != nil {
+    log.Printf("Unable to watch local files, only syncing on the interval\n%v\n", err)
+  } else {
+    defer d.watcher.Close()
+    watchFiles(d.watcher)
+  }
+
+  stop := handleSignals(d)
+  defer stop()
+
+  d.notifyStatus("READY=1")
+  d.run()
+  d.wait()
+  log.Println("Stopped")
+}
+
+// handleSignals stops the daemon on SIGTERM and SIGINT, a second one stops
+// it without waiting for the transfers. SIGHUP reloads and SIGUSR1 syncs
+// right away.
+func handleSignals(d *daemon) func() {
+  signals := make(chan os.Signal, 1)
+  all := append(append(append([]os.Signal{}, stopSignals...), reloadSignals...), syncSignals...)
+  signal.Notify(signals, all...)
+
+  go func() {
+    for sig := range signals {
+      switch {
+        case slices.Contains(stopSignals, sig):
+          if d.ctx.Err() != nil {
+            log.Printf("Received %v again, stopping right away\n", sig)
+            os.Exit(1)
+          }
+          log.Printf("Received %v\n", sig)
+          d.Shutdown()
+        case slices.Contains(reloadSignals, sig):
+          err := d.Reload()
+          if err != nil {
+            log.Printf("Unable to reload\n%v\n", err)
+          }
+        case slices.Contains(syncSignals, sig):
+          d.SyncNow()
+      }
+    }
+  }()
+
+  return func() {
+    signal.Stop(signals)
+  }
+}
+
+// wait gives the transfers that are still running time to stop, they are
+// canceled together with the context of the daemon
+func (d *daemon) wait() {
+  done := make(chan struct{})
+  go func() {
+    d.work.Wait()
+    close(done)
+  }()
+
+  select {
+  case <-done:
+  case <-time.After(SHUTDOWN):
+    log.Printf("Transfers didn't stop within %v\n", SHUTDOWN)
+  }
+}
+
+// run syncs until the daemon is shut down
+func (d *daemon) run() {
+  var events <-chan string
+  if d.watcher != nil {
+    events = d.watcher.Events
+  }
+
+  var notifications <-chan string
+  renewals := make(chan internal.Account)
+  channels := make(map[internal.Account]internal.Channel)
+  receiver := startReceiver(d.webhook, d.listen)
+  if receiver != nil {
+    notifications = receiver.Notifications
+
+    accounts, err := internal.GetAccounts()
+    if err != nil {
+      log.Printf("Unable to get the accounts\n%v\n", err)
+    }
+    for _, account := range accounts {
+      if account.Vendor == internal.GoogleDrive {
+        d.renewChannel(receiver, account, channels, renewals)
+      }
+    }
+  }
+
+  var beats <-chan time.Time
+  if interval := internal.WatchdogInterval(); interval > 0 {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    beats = ticker.C
+    d.beat()
+    go d.pingWatchdog(interval)
+  }
+
+  // Every file is due when the daemon starts
+  d.sync(false)
+
   for {
-    time.Sleep(time.Second * 60 * 15)
+    select {
+    case <-d.ctx.Done():
+      stopChannels(channels)
+      return
+    case <-d.schedule.clock.After(d.nextSync()):
+      d.sync(false)
+    case <-notifications:
+      d.sync(true)
+    case <-d.syncNow:
+      d.sync(true)
+    case <-beats:
+      d.beat()
+    case account := <-renewals:
+      d.renewChannel(receiver, account, channels, renewals)
+    case path := <-events:
+      if !d.isPaused() {
+        d.work.Add(1)
+        go func() {
+          defer d.work.Done()
+          handleLocalChange(path)
+        }()
+      }
+    }
+  }
+}
+
+// pingWatchdog tells systemd the daemon is alive until it is shut down.
+// The pings are sent apart from the syncs, which may take longer than the
+// watchdog allows, but only while the heartbeat shows progress. systemd
+// restarts a daemon that is stuck.
+func (d *daemon) pingWatchdog(interval time.Duration) {
+  pings := time.NewTicker(interval)
+  defer pings.Stop()
+
+  stalled := false
+  for {
+    select {
+    case <-d.ctx.Done():
+      return
+    case <-pings.C:
+      if d.alive() {
+        stalled = false
+        d.notify("WATCHDOG=1")
+      } else if !stalled {
+        stalled = true
+        log.Printf("No progress for %v, the watchdog is not pinged anymore\n", STALLED)
+      }
+    }
+  }
+}
+
+// beat records that the daemon made progress
+func (d *daemon) beat() {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  d.heartbeat = d.schedule.clock.Now()
+}
+
+// alive tells if the daemon made progress recently
+func (d *daemon) alive() bool {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  return d.schedule.clock.Now().Sub(d.heartbeat) < STALLED
+}
+
+// nextSync is how long until the next file is due. A paused daemon syncs
+// all files when it is resumed, and a sync that can't get the files is
+// retried a second later at the soonest.
+func (d *daemon) nextSync() time.Duration {
+  if d.isPaused() {
+    return INTERVAL
+  }
+  return max(d.schedule.wait(), time.Second)
+}
+
+func (d *daemon) isPaused() bool {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  return d.paused
+}
+
+// sync syncs the files that are due, or all of them
+func (d *daemon) sync(all bool) {
+  if d.isPaused() || d.ctx.Err() != nil {
+    return
+  }
+
+  files, err := internal.GetFiles()
+  if err != nil {
+    log.Printf("Unable to get the watched files\n%v\n", err)
+    return
+  }
+
+  due := d.schedule.due(files, all)
+  if len(due) == 0 {
+    return
+  }
+
+  d.mu.Lock()
+  d.syncing = true
+  d.mu.Unlock()
+  d.notifyStatus()
+  defer func() {
+    d.mu.Lock()
+    d.syncing = false
+    d.lastSync = time.Now()
+    d.mu.Unlock()
+    d.notifyStatus()
+  }()
 
-    files, err := internal.GetFiles()
+  for account, f := range groupByAccount(due) {
+    switch account.Vendor {
+    case internal.GoogleDrive:
+      d.handleGoogleDrive(account, f)
+    }
+    d.beat()
+  }
+
+  if d.watcher != nil {
+    watchFiles(d.watcher)
+  }
+}
+
+// notify sends the state to systemd, when it started the daemon
+func (d *daemon) notify(state ...string) {
+  err := internal.Notify(state...)
+  if err != nil {
+    log.Printf("Unable to notify systemd\n%v\n", err)
+  }
+}
+
+// notifyStatus sends what the daemon is doing to systemd, with the state
+func (d *daemon) notifyStatus(state ...string) {
+  d.mu.Lock()
+  var status string
+  switch {
+    case d.paused:
+      status = "Paused"
+    case d.syncing:
+      status = "Syncing"
+    case d.lastSync.IsZero():
+      status = "Idle"
+    default:
+      status = "Idle, last synced at " + d.lastSync.Format(time.TimeOnly)
+  }
+  d.mu.Unlock()
+
+  d.notify(append(state, "STATUS=" + status)...)
+}
+
+// syncInterval returns the sync.interval setting, or INTERVAL when it is
+// invalid
+func syncInterval() time.Duration {
+  interval, err := internal.GetDurationSetting("sync.interval")
+  if err != nil {
+    log.Printf("Unable to get the sync interval, syncing every %v\n%v\n", INTERVAL, err)
+    return INTERVAL
+  }
+  return interval
+}
+
+func notificationSettings() (string, string, error) {
+  webhook, err := internal.GetSetting("google.webhook")
+  if err != nil {
+    return "", "", err
+  }
+  listen, err := internal.GetSetting("google.listen")
+  if err != nil {
+    return "", "", err
+  }
+  return webhook, listen, nil
+}
+
+// startReceiver serves the webhook for Drive push notifications, when one
+// is configured
+func startReceiver(webhook string, listen string) *internal.Receiver {
+  if webhook == "" {
+    return nil
+  }
+
+  receiver := internal.NewReceiver()
+  go func() {
+    err := http.ListenAndServe(listen, receiver)
+    log.Printf("Unable to receive notifications on %v, only syncing on the interval\n%v\n", listen, err)
+  }()
+
+  return receiver
+}
+
+// renewChannel registers a new notification channel for the account and
+// stops its old one. The account is sent to renewals when the new channel
+// needs to be renewed.
+// The receiver accepts the channel before it is registered, as Drive sends
+// its first notification before the registration returns.
+func (d *daemon) renewChannel(receiver *internal.Receiver, account internal.Account, channels map[internal.Account]internal.Channel, renewals chan<- internal.Account) {
+  repo := account.Repository()
+  old := channels[account]
+
+  next := RETRY
+  pending := internal.NewChannel()
+  receiver.Add(pending)
+  channel, err := repo.Watch(d.webhook, pending)
+  if err != nil {
+    receiver.Remove(pending)
+    log.Printf("Unable to register %v for notifications at %v\n%v\n", account, d.webhook, err)
+  } else {
+    receiver.Add(channel)
+    channels[account] = channel
+
+    if old.Id != "" {
+      err = repo.Unwatch(old)
+      if err != nil {
+        log.Printf("Unable to stop the notification channel %v\n%v\n", old.Id, err)
+      }
+      receiver.Remove(old)
+    }
+
+    next = time.Until(channel.Expiration) - RENEW
+    if next < RETRY {
+      next = RETRY
+    }
+  }
+
+  time.AfterFunc(next, func() {
+    select {
+    case renewals <- account:
+    case <-d.ctx.Done():
+    }
+  })
+}
+
+// stopChannels stops the notification channels when shutting down,
+// otherwise Drive keeps sending to the webhook until they expire
+func stopChannels(channels map[internal.Account]internal.Channel) {
+  for account, channel := range channels {
+    err := account.Repository().Unwatch(channel)
     if err != nil {
+      log.Printf("Unable to stop the notification channel %v\n%v\n", channel.Id, err)
+    }
+  }
+}
+
+// handleGoogleDrive syncs the due files of the account. The remote changes
+// are of all files of the account, they are recorded in the database and the
+// ones that aren't due are pulled when they are.
+func (d *daemon) handleGoogleDrive(account internal.Account, due []internal.File) {
+  repo := account.Repository()
+
+  ids, err := repo.Changes()
+  all := err != nil
+  if err != nil && !errors.Is(err, internal.ErrChangesUnknown) {
+    log.Printf("Unable to get the remote changes\n%v\n", err)
+  }
+
+  changed := make(map[string]bool)
+  for _, id := range ids {
+    changed[id] = true
+  }
+
+  var wg sync.WaitGroup
+  slots := make(chan struct{}, PARALLEL)
+  for _, f := range due {
+    if f.Status == internal.Conflict || f.Status == internal.Paused {
       continue
     }
 
-    for vendor, f := range groupByVendor(files) {
-      switch vendor {
-      case internal.GoogleDrive:
-        handleGoogleDrive(f)
-      }
+    // Files that failed before are retried until they are synced
+    pull := all || changed[f.RemoteId] || f.PullNeeded || f.Status != internal.Synced
+
+    wg.Add(1)
+    slots <- struct{}{}
+    go func(f internal.File) {
+      defer wg.Done()
+      defer func() { <-slots }()
+
+      err := syncFile(repo, &f, pull, d.watcher)
+      d.schedule.done(f, err != nil)
+      d.beat()
+    }(f)
+  }
+  wg.Wait()
+}
+
+// syncFile pulls the file when asked to and pushes it when it changed locally
+func syncFile(repo internal.Repository, f *internal.File, pull bool, watcher *internal.Watcher) error {
+  unlock := locks.lock(f.Id)
+  defer unlock()
+
+  var pullErr error
+  if pull {
+    if watcher != nil {
+      watcher.Mute(f.LocalPath)
+    }
+    pullErr = repo.Pull(f)
+    if watcher != nil {
+      watcher.Unmute(f.LocalPath)
+    }
+    if pullErr != nil {
+      log.Printf("Unable to pull %v\n%v\n", f.RemoteName, pullErr)
     }
   }
+
+  if f.Status == internal.Conflict {
+    return pullErr
+  }
+
+  hash, err := internal.HashFile(f.LocalPath)
+  if err != nil {
+    log.Printf("Unable to read %v\n%v\n", f.LocalPath, err)
+    return err
+  }
+  if f.Status == internal.Synced && hash == f.Hash {
+    return pullErr
+  }
+
+  err = repo.Push(f)
+  if err != nil {
+    log.Printf("Unable to push %v\n%v\n", f.RemoteName, err)
+    return err
+  }
+  return pullErr
 }
 
-func handleGoogleDrive(files []internal.File) {
+// handleLocalChange pushes only the file that was written to locally
+func handleLocalChange(path string) {
+  files, err := internal.GetFiles()
+  if err != nil {
+    log.Printf("Unable to get the watched files\n%v\n", err)
+    return
+  }
+
   for _, f := range files {
-    internal.GoogleDrive.Repository().Pull(&f)
-    internal.GoogleDrive.Repository().Push(&f)
+    if filepath.Clean(f.LocalPath) != path || f.Status == internal.Conflict || f.Status == internal.Paused {
+      continue
+    }
+
+    repo := f.Account.Repository()
+    if repo == nil {
+      continue
+    }
+
+    unlock := locks.lock(f.Id)
+    err = repo.Push(&f)
+    unlock()
+    if err != nil {
+      log.Printf("Unable to push %v\n%v\n", f.RemoteName, err)
+    }
   }
 }
 
-func groupByVendor(files []internal.File) map[internal.Vendor][]internal.File {
-  filesPerVendor := make(map[internal.Vendor][]internal.File)
+func watchFiles(watcher *internal.Watcher) {
+  files, err := internal.GetFiles()
+  if err != nil {
+    log.Printf("Unable to get the watched files\n%v\n", err)
+    return
+  }
 
+  paths := make([]string, 0)
   for _, f := range files {
-    _, ok := filesPerVendor[f.Vendor]
-    if !ok {
-      filesPerVendor[f.Vendor] = make([]internal.File, 0)
-    }
-    filesPerVendor[f.Vendor] = append(filesPerVendor[f.Vendor], f)
+    paths = append(paths, f.LocalPath)
+  }
+
+  err = watcher.Watch(paths)
+  if err != nil {
+    log.Printf("Unable to watch the local files\n%v\n", err)
+  }
+}
+
+// groupByAccount splits the files by the account they belong to
+func groupByAccount(files []internal.File) map[internal.Account][]internal.File {
+  filesPerAccount := make(map[internal.Account][]internal.File)
+  for _, f := range files {
+    filesPerAccount[f.Account] = append(filesPerAccount[f.Account], f)
   }
 
-  return filesPerVendor
+  return filesPerAccount
 }
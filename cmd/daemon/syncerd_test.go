@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+  schedule, clock := newTestScheduler(time.Minute)
+  d := &daemon{schedule: schedule}
+
+  if d.alive() {
+    t.Error("alive before the first heartbeat")
+  }
+
+  d.beat()
+  clock.now = clock.now.Add(STALLED - time.Second)
+  if !d.alive() {
+    t.Errorf("not alive %v after the heartbeat", STALLED - time.Second)
+  }
+
+  clock.now = clock.now.Add(time.Second)
+  if d.alive() {
+    t.Errorf("alive %v after the heartbeat", STALLED)
+  }
+
+  d.beat()
+  if !d.alive() {
+    t.Error("not alive after a new heartbeat")
+  }
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"syncer/internal"
+)
+
+// Flag is an option of a command, flags with a Value take an argument
+type Flag struct {
+  Name string
+  // Value names the argument in the help, boolean flags have none
+  Value string
+  Usage string
+}
+
+// Arg is a positional argument of a command. Optional arguments are written
+// in brackets, a trailing "..." accepts any number of them.
+type Arg struct {
+  Name string
+  Usage string
+}
+
+// Command is a subcommand of syncer, its help is generated from it
+type Command struct {
+  Name string
+  // Summary is the line in the list of commands
+  Summary string
+  // Description is printed at the top of the help of the command
+  Description string
+  // Usage replaces the generated usage lines, without "syncer <name>"
+  Usage []string
+  Args []Arg
+  Flags []Flag
+  // Details are printed at the end of the help of the command
+  Details []string
+  // JSON is set for the commands that can print JSON or use --format
+  JSON bool
+  Run func(ctx *Context)
+}
+
+// Context is a parsed command line
+type Context struct {
+  Command *Command
+  Args []string
+  Vendor internal.Vendor
+  // Account is the name given with --account, empty when none is given
+  Account string
+  JSON bool
+  // Format is the template given with --format
+  Format string
+  Verbose bool
+  Help bool
+  flags map[string]string
+}
+
+// Has reports if the flag was given
+func (ctx *Context) Has(name string) bool {
+  _, ok := ctx.flags[name]
+  return ok
+}
+
+// Flag returns the value of the flag, empty when it wasn't given
+func (ctx *Context) Flag(name string) string {
+  return ctx.flags[name]
+}
+
+var globalFlags = []Flag{
+  {Name: "vendor", Value: "name", Usage: "the vendor of the account: " + strings.Join(internal.Vendors(), ", ") + ". The default is google-drive"},
+  {Name: "account", Value: "name", Usage: "the account to use, the default is \"default\". Commands on watched files search every account without it"},
+  {Name: "config", Value: "dir", Usage: "uses another directory for the database and credentials instead of ~/.config/syncer"},
+  {Name: "json", Usage: "prints JSON instead of text, for the commands that support it"},
+  {Name: "format", Value: "template", Usage: "prints the JSON fields with a Go template instead, i.e. '{{range .files}}{{println .remote_name .status}}{{end}}'"},
+  {Name: "verbose", Usage: "prints what is done on stderr"},
+  {Name: "help", Usage: "prints the help of the command"},
+}
+
+func findCommand(name string) *Command {
+  for _, c := range commands {
+    if strings.EqualFold(c.Name, name) {
+      return c
+    }
+  }
+  return nil
+}
+
+func findFlag(flags []Flag, name string) (Flag, bool) {
+  for _, f := range flags {
+    if f.Name == name {
+      return f, true
+    }
+  }
+  return Flag{}, false
+}
+
+// parse splits the command line into the command, its flags and its
+// arguments. Global flags can be given before or after the command.
+func parse(args []string) (*Context, error) {
+  ctx := &Context{flags: make(map[string]string)}
+
+  onlyArgs := false
+  for i := 0; i < len(args); i++ {
+    arg := args[i]
+
+    if onlyArgs || arg == "-" || !strings.HasPrefix(arg, "-") {
+      if ctx.Command == nil {
+        ctx.Command = findCommand(arg)
+        if ctx.Command == nil {
+          return nil, fmt.Errorf("unknown command %v", arg)
+        }
+        continue
+      }
+      ctx.Args = append(ctx.Args, arg)
+      continue
+    }
+    if arg == "--" {
+      onlyArgs = true
+      continue
+    }
+    if arg == "-h" {
+      arg = "--help"
+    }
+    if !strings.HasPrefix(arg, "--") {
+      return nil, fmt.Errorf("unknown flag %v, flags start with --", arg)
+    }
+
+    name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+    flag, ok := findFlag(globalFlags, name)
+    if !ok && ctx.Command != nil {
+      flag, ok = findFlag(ctx.Command.Flags, name)
+    }
+    if !ok {
+      if ctx.Command == nil {
+        return nil, fmt.Errorf("unknown flag --%v", name)
+      }
+      return nil, fmt.Errorf("unknown flag --%v for %v", name, ctx.Command.Name)
+    }
+
+    switch {
+      case flag.Value == "" && hasValue:
+        return nil, fmt.Errorf("--%v takes no value", name)
+      case flag.Value != "" && !hasValue:
+        if i + 1 >= len(args) {
+          return nil, fmt.Errorf("--%v needs a <%v>", name, flag.Value)
+        }
+        i++
+        value = args[i]
+    }
+    ctx.flags[name] = value
+  }
+
+  ctx.Help = ctx.Has("help")
+  ctx.JSON = ctx.Has("json")
+  ctx.Format = ctx.Flag("format")
+  ctx.Verbose = ctx.Has("verbose")
+  ctx.Account = ctx.Flag("account")
+
+  ctx.Vendor = internal.GoogleDrive
+  if ctx.Has("vendor") {
+    vendor, err := internal.ParseVendor(ctx.Flag("vendor"))
+    if err != nil {
+      return nil, err
+    }
+    ctx.Vendor = vendor
+  }
+
+  if ctx.Command == nil || ctx.Help {
+    return ctx, nil
+  }
+
+  if ctx.JSON && ctx.Has("format") {
+    return nil, fmt.Errorf("use only one of --json or --format")
+  }
+  if (ctx.JSON || ctx.Has("format")) && !ctx.Command.JSON {
+    return nil, fmt.Errorf("%v has no JSON output", ctx.Command.Name)
+  }
+
+  min, max := ctx.Command.argCount()
+  if len(ctx.Args) < min || (max >= 0 && len(ctx.Args) > max) {
+    return nil, fmt.Errorf("wrong number of arguments for %v", ctx.Command.Name)
+  }
+
+  return ctx, nil
+}
+
+// argCount returns how many arguments the command takes, max is -1 when
+// there is no limit
+func (c *Command) argCount() (int, int) {
+  min := 0
+  for _, arg := range c.Args {
+    if strings.HasSuffix(strings.TrimRight(arg.Name, "]>"), "...") {
+      if !strings.HasPrefix(arg.Name, "[") {
+        min++
+      }
+      return min, -1
+    }
+    if !strings.HasPrefix(arg.Name, "[") {
+      min++
+    }
+  }
+  return min, len(c.Args)
+}
+
+// usage returns the ways to call the command
+func (c *Command) usage() []string {
+  if len(c.Usage) > 0 {
+    lines := make([]string, 0)
+    for _, u := range c.Usage {
+      lines = append(lines, strings.TrimSpace("syncer " + c.Name + " " + u))
+    }
+    return lines
+  }
+
+  parts := []string{"syncer", c.Name}
+  for _, arg := range c.Args {
+    parts = append(parts, arg.Name)
+  }
+  if len(c.Flags) > 0 {
+    parts = append(parts, "[flags]")
+  }
+  return []string{strings.Join(parts, " ")}
+}
+
+func flagName(f Flag) string {
+  if f.Value == "" {
+    return "--" + f.Name
+  }
+  return fmt.Sprintf("--%v <%v>", f.Name, f.Value)
+}
+
+func printFlags(w io.Writer, flags []Flag) {
+  tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+  for _, f := range flags {
+    fmt.Fprintf(tw, "\t%v\t%v\n", flagName(f), f.Usage)
+  }
+  tw.Flush()
+}
+
+func printGeneralHelp(w io.Writer) {
+  fmt.Fprintln(w, "Syncer is a cloud file system sync tool to keep remote and local files synchronised")
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "Usage:")
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "\tsyncer [global flags] <command> [arguments]")
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "The commands are:")
+  fmt.Fprintln(w)
+
+  tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+  for _, c := range commands {
+    fmt.Fprintf(tw, "\t%v\t%v\n", c.Name, c.Summary)
+  }
+  tw.Flush()
+
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "The global flags are:")
+  fmt.Fprintln(w)
+  printFlags(w, globalFlags)
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "Use \"syncer help <command>\" for more information about a command")
+}
+
+func printCommandHelp(w io.Writer, c *Command) {
+  fmt.Fprintln(w, c.Description)
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "Usage:")
+  fmt.Fprintln(w)
+  for _, line := range c.usage() {
+    fmt.Fprintf(w, "\t%v\n", line)
+  }
+
+  args := make([]Arg, 0)
+  for _, arg := range c.Args {
+    if arg.Usage != "" {
+      args = append(args, arg)
+    }
+  }
+  if len(args) > 0 {
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Arguments:")
+    fmt.Fprintln(w)
+    tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+    for _, arg := range args {
+      fmt.Fprintf(tw, "\t%v\t%v\n", arg.Name, arg.Usage)
+    }
+    tw.Flush()
+  }
+
+  if len(c.Flags) > 0 {
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Flags:")
+    fmt.Fprintln(w)
+    printFlags(w, c.Flags)
+  }
+
+  for _, detail := range c.Details {
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, detail)
+  }
+
+  if c.JSON {
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "The output is printed as JSON with --json, or with a template of its fields with --format")
+  }
+
+  fmt.Fprintln(w)
+  fmt.Fprintln(w, "Use \"syncer help\" for the global flags")
+}
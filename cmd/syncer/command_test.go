@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+  tests := []struct {
+    name string
+    args []string
+    command string
+    rest []string
+    flags map[string]string
+    json bool
+    format string
+    help bool
+    err bool
+  }{
+    {name: "command", args: []string{"ls"}, command: "ls"},
+    {name: "global flag before the command", args: []string{"--account", "work", "ls"}, command: "ls", flags: map[string]string{"account": "work"}},
+    {name: "global flag after the command", args: []string{"ls", "--account", "work"}, command: "ls", flags: map[string]string{"account": "work"}},
+    {name: "flag with =", args: []string{"ls", "--account=work"}, command: "ls", flags: map[string]string{"account": "work"}},
+    {name: "value that looks like a flag", args: []string{"ls", "--account", "--json"}, command: "ls", flags: map[string]string{"account": "--json"}},
+    {name: "command flag", args: []string{"add", "notes.txt", "--merge", "text"}, command: "add", rest: []string{"notes.txt"}, flags: map[string]string{"merge": "text"}},
+    {name: "command flag with =", args: []string{"add", "--interval=5m", "notes.txt"}, command: "add", rest: []string{"notes.txt"}, flags: map[string]string{"interval": "5m"}},
+    {name: "missing value", args: []string{"ls", "--account"}, err: true},
+    {name: "missing command flag value", args: []string{"add", "notes.txt", "--merge"}, err: true},
+    {name: "value for a switch", args: []string{"pull", "--force=yes"}, err: true},
+    {name: "command flag before the command", args: []string{"--force", "pull"}, err: true},
+    {name: "flag of another command", args: []string{"ls", "--force"}, err: true},
+    {name: "unknown flag", args: []string{"ls", "--unknown"}, err: true},
+    {name: "short flag", args: []string{"ls", "-a"}, err: true},
+    {name: "unknown command", args: []string{"unknown"}, err: true},
+    {name: "arguments after --", args: []string{"pull", "--", "--force", "-h"}, command: "pull", rest: []string{"--force", "-h"}},
+    {name: "flags before --", args: []string{"pull", "--force", "--", "-"}, command: "pull", rest: []string{"-"}, flags: map[string]string{"force": ""}},
+    {name: "-h", args: []string{"add", "-h"}, command: "add", help: true, flags: map[string]string{"help": ""}},
+    {name: "-h before the command", args: []string{"-h", "restore"}, command: "restore", help: true, flags: map[string]string{"help": ""}},
+    {name: "--help skips the argument count", args: []string{"restore", "--help"}, command: "restore", help: true, flags: map[string]string{"help": ""}},
+    {name: "no command", args: []string{"--verbose"}, flags: map[string]string{"verbose": ""}},
+    {name: "json", args: []string{"ls", "--json"}, command: "ls", json: true, flags: map[string]string{"json": ""}},
+    {name: "format", args: []string{"ls", "--format", "{{.}}"}, command: "ls", format: "{{.}}", flags: map[string]string{"format": "{{.}}"}},
+    {name: "json and format", args: []string{"ls", "--json", "--format", "{{.}}"}, err: true},
+    {name: "json without JSON output", args: []string{"rm", "notes.txt", "--json"}, err: true},
+    {name: "any number of names", args: []string{"pull", "a", "b", "c"}, command: "pull", rest: []string{"a", "b", "c"}},
+    {name: "no names", args: []string{"push"}, command: "push"},
+    {name: "required argument", args: []string{"rm", "notes.txt"}, command: "rm", rest: []string{"notes.txt"}},
+    {name: "missing required argument", args: []string{"rm"}, err: true},
+    {name: "too many arguments", args: []string{"rm", "a", "b"}, err: true},
+    {name: "optional argument", args: []string{"add", "notes.txt", "notes"}, command: "add", rest: []string{"notes.txt", "notes"}},
+    {name: "two required arguments", args: []string{"restore", "notes.txt"}, err: true},
+  }
+
+  for _, test := range tests {
+    ctx, err := parse(test.args)
+    if test.err {
+      if err == nil {
+        t.Errorf("%v: parsed %v, want an error", test.name, test.args)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%v: %v", test.name, err)
+      continue
+    }
+
+    command := ""
+    if ctx.Command != nil {
+      command = ctx.Command.Name
+    }
+    if command != test.command {
+      t.Errorf("%v: command = %q, want %q", test.name, command, test.command)
+    }
+    if !equalStrings(ctx.Args, test.rest) {
+      t.Errorf("%v: args = %q, want %q", test.name, ctx.Args, test.rest)
+    }
+    if len(ctx.flags) != len(test.flags) {
+      t.Errorf("%v: flags = %v, want %v", test.name, ctx.flags, test.flags)
+    }
+    for name, value := range test.flags {
+      if !ctx.Has(name) || ctx.Flag(name) != value {
+        t.Errorf("%v: --%v = %q, want %q", test.name, name, ctx.Flag(name), value)
+      }
+    }
+    if ctx.JSON != test.json || ctx.Format != test.format || ctx.Help != test.help {
+      t.Errorf("%v: json = %v, format = %q, help = %v, want %v, %q, %v", test.name, ctx.JSON, ctx.Format, ctx.Help, test.json, test.format, test.help)
+    }
+  }
+}
+
+func TestArgCount(t *testing.T) {
+  tests := []struct {
+    args []string
+    min int
+    max int
+  }{
+    {nil, 0, 0},
+    {[]string{"<remote name>"}, 1, 1},
+    {[]string{"[remote name]"}, 0, 1},
+    {[]string{"<remote name>", "[local path]"}, 1, 2},
+    {[]string{"<remote name>", "<version>"}, 2, 2},
+    {[]string{"[key]", "[value]"}, 0, 2},
+    {[]string{"[name...]"}, 0, -1},
+    {[]string{"<name...>"}, 1, -1},
+    {[]string{"<remote name>", "[name...]"}, 1, -1},
+  }
+
+  for _, test := range tests {
+    c := &Command{Name: "test"}
+    for _, name := range test.args {
+      c.Args = append(c.Args, Arg{Name: name})
+    }
+
+    if min, max := c.argCount(); min != test.min || max != test.max {
+      t.Errorf("%v: count = %v, %v, want %v, %v", test.args, min, max, test.min, test.max)
+    }
+  }
+}
+
+func equalStrings(a []string, b []string) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for i := range a {
+    if a[i] != b[i] {
+      return false
+    }
+  }
+  return true
+}
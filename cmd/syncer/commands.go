@@ -0,0 +1,265 @@
+package main
+
+import (
+	"strings"
+
+	"syncer/internal"
+)
+
+var commands []*Command
+
+func init() {
+  commands = []*Command{
+    {
+      Name: "auth",
+      Summary: "authenticates to access the remote files",
+      Description: "Authenticates for syncing to the cloud file management, the account is added when it doesn't exist yet.\nThis allows several accounts of a vendor, chosen with --account",
+      Usage: []string{
+        "[--client-secret <file>] [--device] [--store <store>]",
+        "--service-account <key file> [--subject <user>]",
+      },
+      Flags: []Flag{
+        {Name: "client-secret", Value: "file", Usage: "uses your own OAuth client, as downloaded from the Google Cloud console, instead of the built-in one, for the chosen account only. The file is copied to the config directory"},
+        {Name: "device", Usage: "authenticates on another device by entering a code, for machines without a browser. Google only allows this for clients of the type \"TVs and Limited Input devices\""},
+        {Name: "service-account", Value: "key file", Usage: "authenticates as a service account with its JSON key instead of logging in. The key is kept in the credential store like the tokens"},
+        {Name: "subject", Value: "user", Usage: "the user the service account acts as, requires domain-wide delegation"},
+        {Name: "store", Value: "store", Usage: "moves the saved tokens and service account keys to another store and keeps them there: " + strings.Join(internal.CredentialStores(), ", ")},
+      },
+      Details: []string{
+        "The credential stores are:\n\n" +
+        "\t- file keeps them as plain files in the config directory (default)\n" +
+        "\t- encrypted encrypts them with a passphrase, which is taken from SYNCER_PASSPHRASE or asked for.\n" +
+        "\t  syncerd needs SYNCER_PASSPHRASE to be set\n" +
+        "\t- pass keeps them in pass under syncer/, the command can be changed with\n" +
+        "\t  \"syncer config credentials.command <command>\"",
+      },
+      Run: handleAuth,
+    },
+    {
+      Name: "logout",
+      Summary: "signs out and removes the credentials",
+      Description: "Signs out, the access is revoked at the vendor and the saved credentials are removed",
+      Usage: []string{"[--account <name>] [--pause|--remove]"},
+      Flags: []Flag{
+        {Name: "pause", Usage: "stops syncing the files of the account until \"syncer auth\""},
+        {Name: "remove", Usage: "stops syncing the files of the account for good, the local files are kept"},
+      },
+      Run: handleLogout,
+    },
+    {
+      Name: "status",
+      Summary: "checks the status",
+      Description: "Prints the current status of the daemon, the accounts and the files being watched",
+      JSON: true,
+      Run: handleStatus,
+    },
+    {
+      Name: "browse",
+      Summary: "browse/list the remote vendor",
+      Description: "Browses or lists the remote file server of the account",
+      JSON: true,
+      Run: handleBrowse,
+    },
+    {
+      Name: "start",
+      Summary: "starts the syncer daemon",
+      Description: "Starts the syncer daemon",
+      Run: handleStart,
+    },
+    {
+      Name: "stop",
+      Summary: "stops the syncer daemon",
+      Description: "Stops the syncer daemon",
+      Run: handleStop,
+    },
+    {
+      Name: "service",
+      Summary: "installs the daemon as a systemd user service",
+      Description: "Installs the syncer daemon as a systemd user service that starts when you log in and is restarted when\nit fails, or uninstalls it. \"syncer start\" and \"syncer stop\" use systemd once it is installed",
+      Args: []Arg{
+        {Name: "<install|uninstall>"},
+      },
+      Details: []string{
+        "The unit is written to ~/.config/systemd/user/syncerd.service, with the directory of --config when it is given.\n" +
+        "The daemon logs to the journal, see \"journalctl --user -u syncerd\". The encrypted credential store needs\n" +
+        "SYNCER_PASSPHRASE, which can be added with \"systemctl --user edit syncerd\"",
+      },
+      Run: handleService,
+    },
+    {
+      Name: "pause",
+      Summary: "pauses the syncing of the daemon",
+      Description: "Pauses the syncing of the running daemon until \"syncer resume\", \"syncer pull\" and \"syncer push\" still sync",
+      Run: handleDaemon((*internal.ControlClient).Pause, "Paused the syncer daemon"),
+    },
+    {
+      Name: "resume",
+      Summary: "resumes the syncing of the daemon",
+      Description: "Resumes the syncing of the running daemon, it syncs all files right away",
+      Run: handleDaemon((*internal.ControlClient).Resume, "Resumed the syncer daemon"),
+    },
+    {
+      Name: "reload",
+      Summary: "reloads the watched files and credentials in the daemon",
+      Description: "Has the running daemon pick up the changed watched files and credentials. The commands of syncer that\nchange them do this already",
+      Run: handleDaemon((*internal.ControlClient).Reload, "Reloaded the syncer daemon"),
+    },
+    {
+      Name: "pull",
+      Summary: "pulls the latest version of all files or of specific files",
+      Description: "Pulls the latest version of the watched files from the vendor, a local file that changed as well is\nkept next to the remote version as a conflict. The running daemon pulls them, when there is one",
+      Args: []Arg{
+        {Name: "[name...]", Usage: "the files to pull, every watched file without them. A name is matched against the remote name and the local path, and can contain the wildcards of a glob: *, ? and [...]"},
+      },
+      Flags: []Flag{
+        {Name: "force", Usage: "downloads the remote version even when it didn't change, the local version is overwritten and kept in the history"},
+      },
+      Details: []string{
+        "The result of every file is printed, the exit code is 1 when any of them failed",
+      },
+      JSON: true,
+      Run: handlePull,
+    },
+    {
+      Name: "push",
+      Summary: "pushes the local changes of all files or of specific files",
+      Description: "Pushes the local version of the watched files to the remote repository of the vendor, a remote file\nthat changed as well is kept next to the local file as a conflict. The running daemon pushes them, when there is one",
+      Args: []Arg{
+        {Name: "[name...]", Usage: "the files to push, every watched file without them. A name is matched against the remote name and the local path, and can contain the wildcards of a glob: *, ? and [...]"},
+      },
+      Flags: []Flag{
+        {Name: "force", Usage: "uploads the local version even when it didn't change, the remote version is overwritten"},
+      },
+      Details: []string{
+        "The result of every file is printed, the exit code is 1 when any of them failed",
+      },
+      JSON: true,
+      Run: handlePush,
+    },
+    {
+      Name: "ls",
+      Summary: "lists the watched files",
+      Description: "Prints the files being watched",
+      JSON: true,
+      Run: handleLs,
+    },
+    {
+      Name: "add",
+      Summary: "adds a file to be watched",
+      Description: "Adds the given file to be watched",
+      Args: []Arg{
+        {Name: "<remote name>", Usage: "the name of the remote file, it is unique within the account"},
+        {Name: "[local path]", Usage: "the default is $HOME/syncer/$VENDORDIR/<remote name>, where $VENDORDIR ends with -<account> for accounts other than the default"},
+      },
+      Flags: []Flag{
+        {Name: "merge", Value: "strategy", Usage: "sets how conflicts are merged, see \"syncer help merge\". The default is kdbx for KeePass databases"},
+        {Name: "interval", Value: "duration", Usage: "sets how often the file is synced, see \"syncer help interval\""},
+      },
+      Run: handleAdd,
+    },
+    {
+      Name: "rm",
+      Summary: "removes a file from syncing",
+      Description: "Removes the given file from syncing, the local file is kept",
+      Args: []Arg{{Name: "<remote name>"}},
+      Run: handleRm,
+    },
+    {
+      Name: "conflicts",
+      Summary: "lists and resolves conflicting files",
+      Description: "Lists the files that changed both locally and remotely, or resolves the conflict of a file",
+      Usage: []string{
+        "",
+        "<remote name> --keep-local|--keep-remote|--keep-both",
+        "<remote name> --merge [--key-file <file>] [--no-password]",
+      },
+      Args: []Arg{{Name: "[remote name]"}},
+      Flags: []Flag{
+        {Name: "merge", Usage: "merges both versions with the merge strategy of the file, see \"syncer help merge\""},
+        {Name: "key-file", Value: "file", Usage: "the key file of the KeePass database, besides the password that is asked for"},
+        {Name: "no-password", Usage: "doesn't ask for a password, for databases that only use a key file"},
+        {Name: "keep-local", Usage: "overwrites the remote with the local file and removes the conflict copy"},
+        {Name: "keep-remote", Usage: "overwrites the local file with the remote and removes the conflict copy"},
+        {Name: "keep-both", Usage: "overwrites the remote with the local file and keeps the conflict copy"},
+      },
+      Run: handleConflicts,
+    },
+    {
+      Name: "merge",
+      Summary: "shows or sets the merge strategy of a file",
+      Description: "Shows or sets how the conflicts of a file are merged",
+      Args: []Arg{
+        {Name: "<remote name>"},
+        {Name: "[strategy]", Usage: "one of: " + strings.Join(internal.MergeStrategies(), ", ") + " or none"},
+      },
+      Details: []string{
+        "kdbx merges the entries of two versions of a KeePass database, keeping the older versions in the history.\n" +
+        "text merges the lines changed in both versions, like diff3. It is done right away when a conflict happens,\n" +
+        "lines that are changed in both versions are marked in the local file and the file stays in conflict",
+      },
+      Run: handleMerge,
+    },
+    {
+      Name: "interval",
+      Summary: "shows or sets how often a file is synced",
+      Description: "Shows or sets how often the daemon syncs a file, remote changes are synced right away when Google Drive\nnotifications are set up",
+      Args: []Arg{
+        {Name: "<remote name>"},
+        {Name: "[duration]", Usage: "like 30s, 5m or 1h, default uses the sync.interval setting"},
+      },
+      Details: []string{
+        "The daemon syncs every file when it starts and then on its interval, moved by up to a tenth at random.\n" +
+        "The interval of a file that keeps failing doubles with every failure, up to an hour",
+      },
+      Run: handleInterval,
+    },
+    {
+      Name: "history",
+      Summary: "lists the local versions saved before a pull overwrote them",
+      Description: "Lists the versions of a local file that were saved before a pull overwrote them",
+      Args: []Arg{{Name: "<remote name>"}},
+      Details: []string{
+        "The number of versions and how long they are kept is set with\n" +
+        "\"syncer config history.versions <count>\" and \"syncer config history.days <days>\", 0 keeps them forever",
+      },
+      JSON: true,
+      Run: handleHistory,
+    },
+    {
+      Name: "restore",
+      Summary: "restores a saved local version",
+      Description: "Restores a saved version of a local file, the restored file is pushed with the next sync",
+      Args: []Arg{
+        {Name: "<remote name>"},
+        {Name: "<version>", Usage: "the number of the version as listed by \"syncer history <remote name>\""},
+      },
+      Run: handleRestore,
+    },
+    {
+      Name: "config",
+      Summary: "shows or changes the settings",
+      Description: "Shows or changes the settings",
+      Args: []Arg{
+        {Name: "[key]"},
+        {Name: "[value]"},
+      },
+      Details: []string{
+        "Settings:\n\n" +
+        "\t- history.versions and history.days limit the kept versions of a file\n" +
+        "\t- sync.interval is how often syncerd syncs the files without their own interval, like 30s or 5m\n" +
+        "\t- google.webhook is the public https address Google Drive sends change notifications to, empty disables them\n" +
+        "\t- google.listen is the local address syncerd receives the notifications on\n" +
+        "\t- credentials.command is the pass compatible command of the pass credential store",
+      },
+      JSON: true,
+      Run: handleConfig,
+    },
+    {
+      Name: "help",
+      Summary: "prints the help",
+      Description: "Prints the help of syncer or of a command",
+      Args: []Arg{{Name: "[command]"}},
+      Run: handleHelp,
+    },
+  }
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"syncer/internal"
+)
+
+const (
+  RED = "31"
+  GREEN = "32"
+  YELLOW = "33"
+  MAGENTA = "35"
+  GRAY = "90"
+)
+
+// useColor is set when stdout is a terminal and NO_COLOR isn't set
+var useColor = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+
+func isTerminal(f *os.File) bool {
+  info, err := f.Stat()
+  if err != nil {
+    return false
+  }
+  return info.Mode() & os.ModeCharDevice != 0
+}
+
+// paint colors the text for the terminal
+func paint(color string, text any) string {
+  if !useColor {
+    return fmt.Sprint(text)
+  }
+  return fmt.Sprintf("\033[0;%vm%v\033[0m", color, text)
+}
+
+func statusColor(status internal.Status) string {
+  switch status {
+    case internal.Synced:
+      return GREEN
+    case internal.Conflict:
+      return YELLOW
+    case internal.NeedsReauth:
+      return MAGENTA
+    case internal.Paused:
+      return GRAY
+    default:
+      return RED
+  }
+}
+
+func authStateColor(state internal.AuthState) string {
+  switch state {
+    case internal.Authenticated:
+      return GREEN
+    case internal.Reauthenticate:
+      return MAGENTA
+    case internal.AuthUnknown:
+      return YELLOW
+    default:
+      return RED
+  }
+}
+
+type daemonJSON struct {
+  Running bool `json:"running"`
+  Pids []int `json:"pids"`
+  Started *time.Time `json:"started,omitempty"`
+  LastSync *time.Time `json:"last_sync,omitempty"`
+  Syncing bool `json:"syncing"`
+  Paused bool `json:"paused"`
+}
+
+type accountJSON struct {
+  Vendor string `json:"vendor"`
+  Name string `json:"name"`
+  State string `json:"state,omitempty"`
+}
+
+type fileJSON struct {
+  Id int `json:"id"`
+  RemoteId string `json:"remote_id"`
+  RemoteName string `json:"remote_name"`
+  LocalPath string `json:"local_path"`
+  Account accountJSON `json:"account"`
+  Status string `json:"status"`
+  // LastSync is the time of the last sync, successful or not
+  LastSync string `json:"last_sync"`
+  Hash string `json:"hash"`
+  Revision string `json:"revision"`
+  ConflictPath string `json:"conflict_path,omitempty"`
+  Merge string `json:"merge,omitempty"`
+  // Interval is empty for the files synced at the sync.interval setting
+  Interval string `json:"interval,omitempty"`
+}
+
+type statusJSON struct {
+  Daemon daemonJSON `json:"daemon"`
+  Accounts []accountJSON `json:"accounts"`
+  Files []fileJSON `json:"files"`
+}
+
+type snapshotJSON struct {
+  Version int `json:"version"`
+  Time time.Time `json:"time"`
+  Size int64 `json:"size"`
+  Path string `json:"path"`
+}
+
+// syncJSON is the outcome of pulling or pushing a file
+type syncJSON struct {
+  File fileJSON `json:"file"`
+  Result internal.Outcome `json:"result"`
+  Error string `json:"error,omitempty"`
+}
+
+// remoteFileJSON is a file that can be added, as listed by browse
+type remoteFileJSON struct {
+  Id string `json:"id"`
+  Name string `json:"name"`
+}
+
+func newAccountJSON(account internal.Account) accountJSON {
+  return accountJSON{
+    Vendor: account.Vendor.String(),
+    Name: account.Name,
+  }
+}
+
+func newFileJSON(f internal.File) fileJSON {
+  return fileJSON{
+    Id: f.Id,
+    RemoteId: f.RemoteId,
+    RemoteName: f.RemoteName,
+    LocalPath: f.LocalPath,
+    Account: newAccountJSON(f.Account),
+    Status: f.Status.String(),
+    LastSync: f.LastPulled,
+    Hash: f.Hash,
+    Revision: f.Revision,
+    ConflictPath: f.ConflictPath,
+    Merge: f.Merge,
+    Interval: interval(f),
+  }
+}
+
+func interval(f internal.File) string {
+  if f.Interval == 0 {
+    return ""
+  }
+  return f.Interval.String()
+}
+
+func newFilesJSON(files []internal.File) []fileJSON {
+  list := make([]fileJSON, 0)
+  for _, f := range files {
+    list = append(list, newFileJSON(f))
+  }
+  return list
+}
+
+func newRemoteFilesJSON(files []internal.RemoteFile) []remoteFileJSON {
+  list := make([]remoteFileJSON, 0)
+  for _, f := range files {
+    list = append(list, remoteFileJSON{Id: f.Id, Name: f.Name})
+  }
+  return list
+}
+
+// structured reports if the output is JSON or a template instead of text
+func (ctx *Context) structured() bool {
+  return ctx.JSON || ctx.Format != ""
+}
+
+// output prints the value as JSON or with the template of --format
+func (ctx *Context) output(v any) {
+  if ctx.Format == "" {
+    printJSON(v)
+    return
+  }
+
+  funcs := template.FuncMap{
+    "json": func(v any) (string, error) {
+      b, err := json.Marshal(v)
+      return string(b), err
+    },
+    "join": strings.Join,
+  }
+
+  tmpl, err := template.New("format").Funcs(funcs).Parse(ctx.Format)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Invalid --format template\n%v\n", err)
+    os.Exit(2)
+  }
+
+  // The template sees the same fields as the JSON output
+  b, err := json.Marshal(v)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to encode the output\n%v\n", err)
+    os.Exit(1)
+  }
+  var data any
+  err = json.Unmarshal(b, &data)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to encode the output\n%v\n", err)
+    os.Exit(1)
+  }
+
+  var out strings.Builder
+  err = tmpl.Execute(&out, data)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to execute the --format template\n%v\n", err)
+    os.Exit(1)
+  }
+  fmt.Print(out.String())
+  if !strings.HasSuffix(out.String(), "\n") {
+    fmt.Println()
+  }
+}
+
+// printJSON writes the value as indented JSON to stdout
+func printJSON(v any) {
+  enc := json.NewEncoder(os.Stdout)
+  enc.SetIndent("", "  ")
+  err := enc.Encode(v)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to encode the output\n%v\n", err)
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"syncer/internal"
+)
+
+// SERVICE is the name of the systemd user unit of the daemon
+const SERVICE = "syncerd.service"
+
+// unit is the systemd user unit, the watchdog restarts the daemon when its
+// loop hangs
+const unit = `[Unit]
+Description=Syncer daemon, syncs the watched files with the remote vendors
+
+[Service]
+Type=notify
+ExecStart=%v
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=10s
+WatchdogSec=10min
+%v
+[Install]
+WantedBy=default.target
+`
+
+// servicePath returns where the unit is written, in the systemd user
+// configuration
+func servicePath() (string, error) {
+  dir := os.Getenv("XDG_CONFIG_HOME")
+  if dir == "" {
+    home, err := os.UserHomeDir()
+    if err != nil {
+      return "", err
+    }
+    dir = filepath.Join(home, ".config")
+  }
+
+  return filepath.Join(dir, "systemd", "user", SERVICE), nil
+}
+
+// serviceInstalled reports if the daemon is run by systemd
+func serviceInstalled() bool {
+  path, err := servicePath()
+  if err != nil {
+    return false
+  }
+  _, err = os.Stat(path)
+  return err == nil
+}
+
+// systemctl runs systemctl for the user, its output is shown
+func systemctl(args ...string) error {
+  cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+  cmd.Stdout = os.Stdout
+  cmd.Stderr = os.Stderr
+  return cmd.Run()
+}
+
+func handleService(ctx *Context) {
+  switch ctx.Args[0] {
+    case "install":
+      installService(ctx)
+    case "uninstall":
+      uninstallService(ctx)
+    default:
+      fmt.Fprintf(os.Stderr, "Unknown action %v, use install or uninstall\n", ctx.Args[0])
+      os.Exit(2)
+  }
+}
+
+func installService(ctx *Context) {
+  _, err := exec.LookPath("systemctl")
+  if err != nil {
+    log.Fatalf("Unable to find systemd, use \"syncer start\" instead\n%v\n", err)
+  }
+
+  daemon, err := exec.LookPath(DAEMON)
+  if err != nil {
+    log.Fatalf("Unable to find %v\n%v\n", DAEMON, err)
+  }
+  daemon, err = filepath.Abs(daemon)
+  if err != nil {
+    log.Fatalf("Unable to find %v\n%v\n", DAEMON, err)
+  }
+
+  var environment string
+  if ctx.Has("config") || os.Getenv("SYNCER_CONFIG") != "" {
+    environment = fmt.Sprintf("Environment=\"SYNCER_CONFIG=%v\"\n", internal.ConfigDir())
+  }
+
+  path, err := servicePath()
+  if err != nil {
+    log.Fatalf("Unable to find the systemd configuration\n%v\n", err)
+  }
+  err = os.MkdirAll(filepath.Dir(path), 0755)
+  if err != nil {
+    log.Fatalf("Unable to install the service\n%v\n", err)
+  }
+  err = os.WriteFile(path, []byte(fmt.Sprintf(unit, daemon, environment)), 0644)
+  if err != nil {
+    log.Fatalf("Unable to install the service\n%v\n", err)
+  }
+  ctx.verbosef("Wrote %v\n", path)
+
+  err = systemctl("daemon-reload")
+  if err != nil {
+    // Without the user manager of systemd "syncer start" keeps using nohup
+    os.Remove(path)
+    log.Fatalf("Unable to reload systemd\n%v\n", err)
+  }
+
+  // A daemon that was started without systemd holds the pid file
+  if systemctl("is-active", "--quiet", SERVICE) != nil {
+    stopDaemon(ctx)
+  }
+
+  err = systemctl("enable", SERVICE)
+  if err != nil {
+    log.Fatalf("Unable to enable the service\n%v\n", err)
+  }
+  err = systemctl("restart", SERVICE)
+  if err != nil {
+    log.Fatalf("Unable to start the service\n%v\n", err)
+  }
+
+  fmt.Printf("Installed and started %v, it starts when you log in\n", SERVICE)
+}
+
+func uninstallService(ctx *Context) {
+  path, err := servicePath()
+  if err != nil {
+    log.Fatalf("Unable to find the systemd configuration\n%v\n", err)
+  }
+  if !serviceInstalled() {
+    fmt.Println("The service is not installed")
+    return
+  }
+
+  err = systemctl("disable", "--now", SERVICE)
+  if err != nil {
+    log.Fatalf("Unable to stop the service\n%v\n", err)
+  }
+
+  err = os.Remove(path)
+  if err != nil {
+    log.Fatalf("Unable to remove the service\n%v\n", err)
+  }
+
+  err = systemctl("daemon-reload")
+  if err != nil {
+    log.Fatalf("Unable to reload systemd\n%v\n", err)
+  }
+
+  fmt.Printf("Stopped and removed %v\n", SERVICE)
+}
+
+// stopDaemon shuts down a running daemon and waits until it released its
+// pid file
+func stopDaemon(ctx *Context) {
+  client, err := internal.DialControl()
+  if err != nil {
+    return
+  }
+  err = client.Shutdown()
+  client.Close()
+  if err != nil {
+    log.Fatalf("Unable to stop the running syncer daemon\n%v\n", err)
+  }
+  ctx.verbosef("Stopped the syncer daemon that was started without systemd\n")
+
+  deadline := time.Now().Add(STARTUP)
+  for time.Now().Before(deadline) {
+    _, err := os.Stat(internal.PidFilePath())
+    if os.IsNotExist(err) {
+      return
+    }
+    time.Sleep(time.Millisecond * 100)
+  }
+  log.Fatalf("The syncer daemon didn't stop, stop it with \"syncer stop\" and try again\n")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"os"
@@ -8,14 +9,18 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"syncer/internal"
 )
 
 const (
   DAEMON = "syncerd"
+  // STARTUP is how long the daemon gets to open its control socket
+  STARTUP = time.Second * 5
 )
 
 func main() {
@@ -24,71 +29,169 @@ func main() {
     os.Exit(1)
   }
 
-  err := internal.Init()
+  ctx, err := parse(os.Args[1:])
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%v\n", err)
+    if cmd := commandName(os.Args[1:]); cmd != "" {
+      fmt.Fprintf(os.Stderr, "Use \"syncer help %v\" to see what arguments to use\n", cmd)
+    } else {
+      fmt.Fprintln(os.Stderr, "Use \"syncer help\" to see the commands")
+    }
+    os.Exit(2)
+  }
+
+  if ctx.Command == nil {
+    printGeneralHelp(os.Stdout)
+    return
+  }
+  if ctx.Help {
+    printCommandHelp(os.Stdout, ctx.Command)
+    return
+  }
+
+  if ctx.Has("config") {
+    internal.SetConfigDir(ctx.Flag("config"))
+  }
+  err = internal.Init()
   if err != nil {
     fmt.Printf("Unable to initialize the configuration\n")
     fmt.Printf("%v\n", err)
     os.Exit(1)
   }
+  ctx.verbosef("Using the configuration in %v\n", internal.ConfigDir())
 
-  if len(os.Args) == 1 {
-    printGeneralHelp()
-    os.Exit(0)
-  }
-
-  // TODO: handle by flag
-  var vendor internal.Vendor = internal.GoogleDrive
-
-  handleCommand(os.Args[1], vendor, os.Args[2:]..., )
-}
-
-func handleCommand(command string, vendor internal.Vendor, args ...string) {
-  switch strings.ToLower(command) {
-    case "pull":
-      handlePull(vendor)
-    case "push":
-      handlePush(vendor)
-    case "browse":
-      handleBrowse(vendor)
-    case "auth":
-      handleAuth(vendor)
-    case "status":
-      handleStatus()
-    case "start":
-      handleStart()
-    case "stop":
-      handleStop()
-    case "ls":
-      handleLs()
-    case "add":
-      handleAdd(vendor, args)
-    case "rm":
-      handleRm(args)
-    case "help":
-      if len(args) > 0 {
-        printCommandHelp(args[0])
-      } else {
-        printGeneralHelp()
-      }
-    default:
-      printGeneralHelp()
+  internal.Passphrase = func() (string, error) {
+    if passphrase := os.Getenv("SYNCER_PASSPHRASE"); passphrase != "" {
+      return passphrase, nil
+    }
+    return readPassword("Passphrase of the credentials: ")
+  }
+
+  ctx.Command.Run(ctx)
+}
+
+// commandName returns the name of the command on the command line, if it
+// is a known one
+func commandName(args []string) string {
+  for _, arg := range args {
+    if c := findCommand(arg); c != nil {
+      return c.Name
+    }
+  }
+  return ""
+}
+
+// verbosef prints on stderr when --verbose is given
+func (ctx *Context) verbosef(format string, args ...any) {
+  if ctx.Verbose {
+    fmt.Fprintf(os.Stderr, format, args...)
+  }
+}
+
+// account returns the account chosen with --vendor and --account
+func (ctx *Context) account() internal.Account {
+  account, err := internal.GetAccount(ctx.Vendor, ctx.Account)
+  if err != nil {
+    log.Fatalf("%v\n", err)
+  }
+  ctx.verbosef("Using the account %v\n", account)
+  return account
+}
+
+func handleHelp(ctx *Context) {
+  if len(ctx.Args) == 0 {
+    printGeneralHelp(os.Stdout)
+    return
   }
+
+  c := findCommand(ctx.Args[0])
+  if c == nil {
+    fmt.Fprintf(os.Stderr, "Unknown command %v\n", ctx.Args[0])
+    os.Exit(2)
+  }
+  printCommandHelp(os.Stdout, c)
 }
 
-func handleStart() {
+func handleStart(ctx *Context) {
+  client, err := internal.DialControl()
+  if err == nil {
+    client.Close()
+    fmt.Println("The syncer daemon is running already")
+    return
+  }
+
+  if serviceInstalled() {
+    err = systemctl("start", SERVICE)
+    if err != nil {
+      log.Fatalf("Unable to start syncer\n%v\n", err)
+    }
+    fmt.Println("Started the syncer daemon")
+    return
+  }
+
   cmd := exec.Command("nohup", DAEMON)
-  err := cmd.Start()
+  if ctx.Has("config") {
+    cmd.Env = append(os.Environ(), "SYNCER_CONFIG=" + internal.ConfigDir())
+  }
+  err = cmd.Start()
   if err != nil {
     log.Fatalf("Unable to start syncer\n%v\n", err)
   }
-  fmt.Println("Started the syncer daemon")
+
+  // The daemon is up once it answers on its control socket
+  exited := make(chan error, 1)
+  go func() {
+    exited <- cmd.Wait()
+  }()
+  deadline := time.After(STARTUP)
+  for {
+    select {
+      case err := <-exited:
+        log.Fatalf("The syncer daemon exited right away\n%v\n", err)
+      case <-deadline:
+        log.Fatalf("The syncer daemon doesn't answer on %v\n", internal.ControlSocket())
+      case <-time.After(time.Millisecond * 100):
+    }
+
+    client, err := internal.DialControl()
+    if err == nil {
+      client.Close()
+      fmt.Println("Started the syncer daemon")
+      return
+    }
+  }
 }
 
-func handleStop() {
+func handleStop(ctx *Context) {
+  if serviceInstalled() && systemctl("is-active", "--quiet", SERVICE) == nil {
+    err := systemctl("stop", SERVICE)
+    if err != nil {
+      log.Fatalf("Unable to stop syncer\n%v\n", err)
+    }
+    fmt.Println("Stopped the syncer daemon")
+    return
+  }
+
+  client, err := internal.DialControl()
+  if err == nil {
+    defer client.Close()
+    err = client.Shutdown()
+    if err != nil {
+      log.Fatalf("Unable to stop syncer\n%v\n", err)
+    }
+    fmt.Println("Stopped the syncer daemon")
+    return
+  }
+  ctx.verbosef("Unable to reach the daemon on its control socket, looking for its process\n%v\n", err)
+
+  // Daemons without a control socket are stopped by signal
   pids, err := findPid(DAEMON)
   if err != nil {
     fmt.Printf("Unable to find the process for syncer daemon\n%v\n", err)
   }
+  if len(pids) == 0 {
+    fmt.Println("The syncer daemon is not running")
+  }
 
   for _, pid := range pids {
     cmd := exec.Command("kill", strconv.Itoa(pid))
@@ -100,77 +203,471 @@ func handleStop() {
   }
 }
 
-func handlePush(vendor internal.Vendor) {
-  files, err := internal.GetFiles()
+// handleDaemon sends a request without a result to the running daemon
+func handleDaemon(request func(*internal.ControlClient) error, done string) func(ctx *Context) {
+  return func(ctx *Context) {
+    client, err := internal.DialControl()
+    if err != nil {
+      log.Fatalf("%v\n", err)
+    }
+    defer client.Close()
+
+    err = request(client)
+    if err != nil {
+      log.Fatalf("%v\n", err)
+    }
+    fmt.Println(done)
+  }
+}
+
+// reloadDaemon tells a running daemon about changed files or credentials
+func reloadDaemon(ctx *Context) {
+  client, err := internal.DialControl()
   if err != nil {
-    log.Fatalf("Unable to get the watched files\n%v\n", err)
+    return
   }
+  defer client.Close()
 
-  repo := vendor.Repository()
-  err = repo.PushAll(files)
+  err = client.Reload()
   if err != nil {
-    fmt.Printf("Unable to pull files from %v\n%v\n", vendor, err)
+    fmt.Fprintf(os.Stderr, "Unable to reload the syncer daemon, restart it to apply the change\n%v\n", err)
+    return
   }
+  ctx.verbosef("Reloaded the syncer daemon\n")
+}
+
+func handlePush(ctx *Context) {
+  os.Exit(syncFiles(ctx, true))
 }
 
-func handlePull(vendor internal.Vendor) {
+func handlePull(ctx *Context) {
+  os.Exit(syncFiles(ctx, false))
+}
+
+// syncFiles pulls or pushes the files named in the arguments, or every
+// watched file without arguments, and prints the result of each file.
+// A running daemon syncs the files, so it doesn't sync them at the same time.
+// It returns the exit code, which is 1 when a file failed.
+func syncFiles(ctx *Context, push bool) int {
   files, err := internal.GetFiles()
   if err != nil {
     log.Fatalf("Unable to get the watched files\n%v\n", err)
   }
 
-  repo := vendor.Repository()
-  err = repo.PullAll(files)
+  selected, err := selectFiles(ctx.filter(files), ctx.Args)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%v\n", err)
+    return 1
+  }
+
+  options := syncOptions(ctx, push)
+  var results []syncJSON
+  client, err := internal.DialControl()
+  if err == nil {
+    defer client.Close()
+    ctx.verbosef("Syncing with the running daemon\n")
+    results = syncWithDaemon(ctx, client, selected, options)
+  } else {
+    results = make([]syncJSON, 0)
+    for _, f := range selected {
+      result := syncFile(ctx, f, options)
+      results = append(results, result)
+      if !ctx.structured() {
+        printSyncResult(f, result)
+      }
+    }
+  }
+
+  failed := countFailed(results)
+  if ctx.structured() {
+    ctx.output(results)
+  } else if len(selected) > 1 {
+    fmt.Printf("\n%v files, %v failed\n", len(selected), failed)
+  }
+
+  if failed > 0 {
+    return 1
+  }
+  return 0
+}
+
+func syncOptions(ctx *Context, push bool) internal.SyncOptions {
+  return internal.SyncOptions{Push: push, Force: ctx.Has("force")}
+}
+
+// countFailed counts the files that were not synced, a push refused because
+// the remote changed is one of them
+func countFailed(results []syncJSON) int {
+  failed := 0
+  for _, result := range results {
+    if result.Result.Failure() {
+      failed++
+    }
+  }
+  return failed
+}
+
+// skip tells if the file is left out, paused files are only synced when
+// they are asked for by name
+func skip(ctx *Context, f internal.File) (syncJSON, bool) {
+  if len(ctx.Args) == 0 && f.Status == internal.Paused {
+    return syncJSON{File: newFileJSON(f), Result: internal.Skipped, Error: internal.ErrPaused.Error()}, true
+  }
+  return syncJSON{}, false
+}
+
+func syncFile(ctx *Context, f internal.File, options internal.SyncOptions) syncJSON {
+  if result, ok := skip(ctx, f); ok {
+    return result
+  }
+
+  repo := f.Account.Repository()
+  if repo == nil {
+    return syncJSON{File: newFileJSON(f), Result: internal.Failed, Error: "the vendor is not supported"}
+  }
+
+  if options.Push {
+    ctx.verbosef("Pushing %v to %v\n", f.LocalPath, f.Account)
+  } else {
+    ctx.verbosef("Pulling %v from %v\n", f.LocalPath, f.Account)
+  }
+
+  outcome, err := internal.SyncFile(repo, &f, options)
   if err != nil {
-    fmt.Printf("Unable to pull files from %v\n%v\n", vendor, err)
+    return syncJSON{File: newFileJSON(f), Result: outcome, Error: err.Error()}
   }
+  return syncJSON{File: newFileJSON(f), Result: outcome}
 }
 
-func handleAuth(vendor internal.Vendor) {
-  err := vendor.Repository().Authenticate()
+// syncWithDaemon has the daemon sync the files and prints the results
+func syncWithDaemon(ctx *Context, client *internal.ControlClient, selected []internal.File, options internal.SyncOptions) []syncJSON {
+  request := internal.SyncRequest{Push: options.Push, Force: options.Force}
+  skipped := make(map[int]syncJSON)
+  for _, f := range selected {
+    if result, ok := skip(ctx, f); ok {
+      skipped[f.Id] = result
+      continue
+    }
+    request.Ids = append(request.Ids, f.Id)
+  }
+
+  responses := make(map[int]internal.SyncResponse)
+  if len(request.Ids) > 0 {
+    list, err := client.Sync(request)
+    if err != nil {
+      log.Fatalf("Unable to sync with the syncer daemon\n%v\n", err)
+    }
+    for _, response := range list {
+      responses[response.Id] = response
+    }
+  }
+
+  // The daemon changed the files, their new state is in the database
+  files, err := internal.GetFiles()
   if err != nil {
-    log.Fatalf("Error authenticating google drive\n%v\n", err)
+    log.Fatalf("Unable to get the watched files\n%v\n", err)
+  }
+  current := make(map[int]internal.File)
+  for _, f := range files {
+    current[f.Id] = f
+  }
+
+  results := make([]syncJSON, 0)
+  for _, f := range selected {
+    result, ok := skipped[f.Id]
+    if !ok {
+      if c, ok := current[f.Id]; ok {
+        f = c
+      }
+      response := responses[f.Id]
+      result = syncJSON{File: newFileJSON(f), Result: response.Outcome, Error: response.Error}
+      if response.Outcome == "" {
+        result.Result = internal.Failed
+        result.Error = "the daemon didn't sync the file"
+      }
+    }
+
+    results = append(results, result)
+    if !ctx.structured() {
+      printSyncResult(f, result)
+    }
+  }
+
+  return results
+}
+
+func printSyncResult(f internal.File, result syncJSON) {
+  name := fmt.Sprintf("%v (%v)", f.RemoteName, f.Account)
+
+  switch result.Result {
+    case internal.Failed:
+      fmt.Printf("%v: %v\n\t%v\n", name, paint(RED, result.Result), strings.ReplaceAll(result.Error, "\n", "\n\t"))
+    case internal.PullFirst:
+      fmt.Printf("%v: %v\n", name, paint(RED, result.Result))
+    case internal.PushFirst:
+      fmt.Printf("%v: %v\n", name, paint(YELLOW, result.Result))
+    case internal.Skipped:
+      fmt.Printf("%v: %v, %v\n", name, paint(GRAY, result.Result), result.Error)
+    case internal.InConflict:
+      fmt.Printf("%v: %v, merged partially, use \"syncer conflicts\" to resolve it\n", name, paint(YELLOW, result.Result))
+    default:
+      fmt.Printf("%v: %v\n", name, paint(GREEN, result.Result))
+  }
+}
+
+// selectFiles returns the files that match any of the patterns, every file
+// without patterns. The patterns are matched against the remote name and
+// the local path, as in filepath.Match.
+func selectFiles(files []internal.File, patterns []string) ([]internal.File, error) {
+  if len(patterns) == 0 {
+    return files, nil
+  }
+
+  selected := make([]internal.File, 0)
+  seen := make(map[int]bool)
+  for _, pattern := range patterns {
+    local, err := filepath.Abs(pattern)
+    if err != nil {
+      return nil, err
+    }
+
+    found := false
+    for _, f := range files {
+      match, err := matchFile(f, pattern, local)
+      if err != nil {
+        return nil, fmt.Errorf("Invalid pattern %v\n%v", pattern, err)
+      }
+      if !match {
+        continue
+      }
+
+      found = true
+      if !seen[f.Id] {
+        seen[f.Id] = true
+        selected = append(selected, f)
+      }
+    }
+    if !found {
+      return nil, fmt.Errorf("No watched file matches %v, use \"syncer ls\" to list them", pattern)
+    }
   }
+
+  return selected, nil
 }
 
-func handleStatus() {
-  ids, err := findPid("syncerd")
+func matchFile(f internal.File, pattern string, local string) (bool, error) {
+  match, err := filepath.Match(pattern, f.RemoteName)
+  if err != nil || match {
+    return match, err
+  }
+
+  match, err = filepath.Match(pattern, f.LocalPath)
+  if err != nil || match {
+    return match, err
+  }
+
+  return filepath.Match(local, f.LocalPath)
+}
+
+func handleAuth(ctx *Context) {
+  name := ctx.Account
+  if name == "" {
+    name = internal.DefaultAccount
+  }
+  account, err := internal.AddAccount(ctx.Vendor, name)
   if err != nil {
-    fmt.Println("Unable to check if the daemon is running")
+    log.Fatalf("Unable to add the account\n%v\n", err)
   }
-  if len(ids) > 0 {
-    fmt.Println("Syncer is \033[0;32mrunning\033[0;37m")
-  } else {
-    fmt.Println("Syncer has \033[0;31mstopped\033[0;37m")
+  repo := account.Repository()
+
+  if ctx.Has("store") {
+    store := ctx.Flag("store")
+    err := internal.SetCredentialStore(store)
+    if err != nil {
+      log.Fatalf("Unable to change the credential store\n%v\n", err)
+    }
+    fmt.Printf("Keeping the credentials in the %v store\n", store)
   }
 
-  fmt.Println()
-  if internal.GoogleDrive.Repository().IsAuthenticated() {
-    fmt.Printf("Google Drive is \033[0;32mauthenticated\033[0;37m\n")
+  if ctx.Has("client-secret") {
+    secret := ctx.Flag("client-secret")
+    err := repo.SetClientSecret(secret)
+    if err != nil {
+      log.Fatalf("Unable to use the client secret\n%v\n", err)
+    }
+    fmt.Printf("Using the client from %v for %v\n", secret, account)
+  }
+
+  options := internal.AuthOptions{
+    Device: ctx.Has("device"),
+    ServiceAccount: ctx.Flag("service-account"),
+    Subject: ctx.Flag("subject"),
+  }
+  if options.Subject != "" && options.ServiceAccount == "" {
+    fmt.Println("--subject can only be used with --service-account")
+    return
+  }
+
+  err = repo.Authenticate(options)
+  if err != nil {
+    log.Fatalf("Error authenticating %v\n%v\n", account, err)
+  }
+
+  err = internal.ResumeFiles(account)
+  if err != nil {
+    log.Fatalf("Unable to resume the paused files\n%v\n", err)
+  }
+  reloadDaemon(ctx)
+}
+
+func handleLogout(ctx *Context) {
+  pause := ctx.Has("pause")
+  remove := ctx.Has("remove")
+  if pause && remove {
+    fmt.Println("Use only one of --pause or --remove")
+    fmt.Println("Use \"syncer help logout\" to see what arguments to use")
+    return
+  }
+
+  account := ctx.account()
+  err := account.Repository().Revoke()
+  if err != nil {
+    fmt.Printf("%v\n", err)
   } else {
-    fmt.Printf("Google Drive is \033[0;31mnot authenticated\033[0;37m\n")
+    fmt.Printf("Logged out of %v\n", account)
   }
 
+  switch {
+    case pause:
+      err = internal.PauseFiles(account)
+      if err != nil {
+        log.Fatalf("Unable to pause the files\n%v\n", err)
+      }
+      fmt.Println("Paused syncing the files until \"syncer auth\"")
+    case remove:
+      files, err := internal.GetFiles()
+      if err != nil {
+        log.Fatalf("Unable to get the watched files\n%v\n", err)
+      }
+      for _, f := range files {
+        if f.Account.Id != account.Id {
+          continue
+        }
+        err = internal.RemoveFile(f)
+        if err != nil {
+          log.Fatalf("Unable to remove %v\n%v\n", f.RemoteName, err)
+        }
+        fmt.Printf("Stopped syncing %v, the local file is kept\n", f.RemoteName)
+      }
+  }
+  reloadDaemon(ctx)
+}
+
+func handleStatus(ctx *Context) {
+  status := statusJSON{
+    Accounts: make([]accountJSON, 0),
+  }
+
+  status.Daemon = daemonStatus(ctx)
+
+  accounts, err := internal.GetAccounts()
+  if err != nil {
+    fmt.Fprintln(os.Stderr, "Unable to list the accounts")
+  }
+  if len(accounts) == 0 {
+    account, err := internal.GetAccount(internal.GoogleDrive, "")
+    if err == nil {
+      accounts = append(accounts, account)
+    }
+  }
+
+  states := make([]internal.AuthState, 0)
+  for _, account := range accounts {
+    state := account.Repository().AuthState()
+    states = append(states, state)
+
+    a := newAccountJSON(account)
+    a.State = state.String()
+    status.Accounts = append(status.Accounts, a)
+  }
 
   files, err := internal.GetFiles()
   if err != nil {
-    fmt.Println("Unable to list files")
+    fmt.Fprintln(os.Stderr, "Unable to list files")
+  }
+  files = ctx.filter(files)
+  status.Files = newFilesJSON(files)
+
+  if ctx.structured() {
+    ctx.output(status)
+    return
+  }
+
+  switch {
+    case status.Daemon.Paused:
+      fmt.Printf("Syncer is %v, run \"syncer resume\" to sync again\n", paint(GRAY, "paused"))
+    case status.Daemon.Syncing:
+      fmt.Printf("Syncer is %v\n", paint(GREEN, "syncing"))
+    case status.Daemon.Running:
+      fmt.Printf("Syncer is %v\n", paint(GREEN, "running"))
+    default:
+    fmt.Printf("Syncer has %v\n", paint(RED, "stopped"))
+  }
+  if status.Daemon.LastSync != nil {
+    fmt.Printf("Last synced at %v\n", status.Daemon.LastSync.Format("2006-01-02 15:04:05"))
+  }
+
+  fmt.Println()
+  for i, account := range accounts {
+    state := states[i]
+    if state == internal.Reauthenticate {
+      fmt.Printf("%v is %v, run \"syncer auth%v\"\n", account, paint(authStateColor(state), state), accountFlag(account))
+    } else {
+      fmt.Printf("%v is %v\n", account, paint(authStateColor(state), state))
+    }
   }
 
   if len(files) > 0 {
     fmt.Println()
   }
 
-  for _, f := range files{
-    switch f.Status {
-      case internal.Error:
-        fmt.Printf("%v (%v): \033[0;31m%v\033[0;37m\n", f.RemoteName, f.Vendor, f.Status)
-      case internal.Synced:
-        fmt.Printf("%v (%v): \033[0;32m%v\033[0;37m\n", f.RemoteName, f.Vendor, f.Status)
-      default:
-        fmt.Printf("%v: Unknown\n", f.RemoteName)
+  for _, f := range files {
+    fmt.Printf("%v (%v): %v\n", f.RemoteName, f.Account, paint(statusColor(f.Status), f.Status))
+  }
+}
+
+// daemonStatus asks the running daemon for its state, daemons without a
+// control socket are found by their process
+func daemonStatus(ctx *Context) daemonJSON {
+  client, err := internal.DialControl()
+  if err != nil {
+    ctx.verbosef("Unable to reach the daemon on its control socket\n%v\n", err)
+
+    ids, err := findPid(DAEMON)
+    if err != nil {
+      fmt.Fprintln(os.Stderr, "Unable to check if the daemon is running")
     }
+    return daemonJSON{Running: len(ids) > 0, Pids: ids}
   }
+  defer client.Close()
+
+  s, err := client.Status()
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "Unable to get the status of the daemon\n%v\n", err)
+    return daemonJSON{Running: true, Pids: []int{}}
+  }
+
+  status := daemonJSON{
+    Running: true,
+    Pids: []int{s.Pid},
+    Started: &s.Started,
+    Syncing: s.Syncing,
+    Paused: s.Paused,
+  }
+  if !s.LastSync.IsZero() {
+    status.LastSync = &s.LastSync
+  }
+  return status
 }
 
 func findPid(name string) ([]int, error) {
@@ -204,44 +701,52 @@ func findPid(name string) ([]int, error) {
   return ids, nil
 }
 
-func handleBrowse(vendor internal.Vendor) {
-  files, err := vendor.Repository().List() 
+func handleBrowse(ctx *Context) {
+  account := ctx.account()
+  files, err := account.Repository().List() 
   if err != nil {
-    log.Fatalf("Unable to get remote files from %v\n%v", vendor, err)
+    log.Fatalf("Unable to get remote files from %v\n%v", account, err)
+  }
+
+  if ctx.structured() {
+    ctx.output(newRemoteFilesJSON(files))
+    return
   }
-  fmt.Printf("Files in %v\n\n", vendor)
-  for _, filename := range files {
-    fmt.Printf("%v\n", filename)
+  fmt.Printf("Files in %v\n\n", account)
+  for _, f := range files {
+    fmt.Printf(" - %v\n", f.Name)
   }
 }
 
-func handleLs() {
-  fmt.Println("Files being watched")
+func handleLs(ctx *Context) {
   files, err := internal.GetFiles()
   if err != nil {
-    fmt.Println("Unable to list files")
+    log.Fatalf("Unable to list files\n%v\n", err)
   }
+  files = ctx.filter(files)
 
+  if ctx.structured() {
+    ctx.output(newFilesJSON(files))
+    return
+  }
+
+  fmt.Println("Files being watched")
   if len(files) > 0 {
     fmt.Println()
   }
 
-  for _, f := range files{
-    fmt.Printf("%v (%v) => %v\n", f.RemoteName, f.Vendor, f.LocalPath)
+  for _, f := range files {
+    fmt.Printf("%v (%v) => %v\n", f.RemoteName, f.Account, f.LocalPath)
   }
 }
 
-func handleAdd(vendor internal.Vendor, args []string) {
-  if len(args) < 1 {
-    fmt.Println("Arguments given are not compatible")
-    fmt.Println("Use \"syncer help add\" to see what arguments to use")
-    return
-  }
-  remote := args[0]
+func handleAdd(ctx *Context) {
+  remote := ctx.Args[0]
+  account := ctx.account()
 
   var local string
-  if len(args) > 1 {
-    local = args[1]
+  if len(ctx.Args) > 1 {
+    local = ctx.Args[1]
   } else {
     user, err := user.Current()
     if err != nil {
@@ -250,12 +755,15 @@ func handleAdd(vendor internal.Vendor, args []string) {
     }
 
     var vendorDir string 
-    switch vendor {
+    switch account.Vendor {
       case internal.GoogleDrive:
         vendorDir = "google-drive"
       default:
         vendorDir = ""
     }
+    if account.Name != internal.DefaultAccount {
+      vendorDir += "-" + account.Name
+    }
 
     path := filepath.Join(user.HomeDir, "syncer", vendorDir, remote)
 
@@ -266,7 +774,26 @@ func handleAdd(vendor internal.Vendor, args []string) {
     }
   }
 
-  id, err := vendor.Repository().GetRemoteId(remote) 
+  merge := ctx.Flag("merge")
+  if !ctx.Has("merge") {
+    merge = internal.DefaultMergeStrategy(remote)
+  }
+  if !internal.IsMergeStrategy(merge) {
+    fmt.Printf("Unknown merge strategy %v, use one of: %v\n", merge, strings.Join(internal.MergeStrategies(), ", "))
+    return
+  }
+
+  var interval time.Duration
+  if ctx.Has("interval") {
+    var err error
+    interval, err = internal.ParseInterval(ctx.Flag("interval"))
+    if err != nil {
+      fmt.Printf("Invalid interval %v, use a duration like 30s or 5m\n", ctx.Flag("interval"))
+      return
+    }
+  }
+
+  id, err := account.Repository().GetRemoteId(remote) 
   if err != nil {
     log.Fatalf("Unable to find remote file")
   }
@@ -275,116 +802,348 @@ func handleAdd(vendor internal.Vendor, args []string) {
     RemoteId: id,
     RemoteName: remote,
     LocalPath: local,
-    Vendor: internal.GoogleDrive,
+    Vendor: account.Vendor,
+    Account: account,
+    Merge: merge,
+    Interval: interval,
   }
 
   err = internal.AddFile(f)
   if err != nil {
     log.Fatalf("Unable to add file")
   }
+  reloadDaemon(ctx)
 }
 
-func handleRm(args []string) {
-  if len(args) < 1 {
-    fmt.Println("Arguments given are not compatible")
-    fmt.Println("Use \"syncer help rm\" to see what arguments to use")
-    return
+func handleRm(ctx *Context) {
+  f, err := ctx.findFile(ctx.Args[0])
+  if err != nil {
+    log.Fatalf("%v\n", err)
   }
-  name := args[0]
 
-  err := internal.RemoveFile(name)
+  err = internal.RemoveFile(f)
   if err != nil {
     fmt.Println("Unable to remove file")
+    return
   }
+  reloadDaemon(ctx)
 }
 
-func printGeneralHelp() {
-  fmt.Println("Syncer is a cloud file system sync tool to keep remote and local files synchronised")
-  fmt.Println()
-  fmt.Println("Usage:")
-  fmt.Println()
-  fmt.Println("\tsyncer <command> [arguments]")
-  fmt.Println()
-  fmt.Println("The commands are:")
-  fmt.Println()
-  fmt.Println("\tauth\tauthenticates to access the remote files")
-  fmt.Println("\tstatus\tchecks the status")
-  fmt.Println("\tbrowse\tbrowse/list the remote vendor")
-  fmt.Println("\tstart\tstarts the syncer daemon")
-  fmt.Println("\tstop\tstops the syncer daemon")
-  fmt.Println("\tpull\tpulls the latest version of all files or a specific file")
-  fmt.Println("\tpush\tpushes the local changes the the remote repository")
-  fmt.Println("\tls\tlists the watched files")
-  fmt.Println("\tadd\tadds a file to be watched")
-  fmt.Println("\trm\tremoves a file from syncing")
-  fmt.Println("\thelp\tprints the help")
-  fmt.Println()
-  fmt.Println("Use \"syncer help <command>\" for more information about a command")
+func handleMerge(ctx *Context) {
+  f, err := ctx.findFile(ctx.Args[0])
+  if err != nil {
+    log.Fatalf("%v\n", err)
+  }
+
+  if len(ctx.Args) < 2 {
+    if f.Merge == "" {
+      fmt.Printf("%v has no merge strategy\n", f.RemoteName)
+    } else {
+      fmt.Printf("%v is merged with the %v strategy\n", f.RemoteName, f.Merge)
+    }
+    return
+  }
+
+  strategy := ctx.Args[1]
+  if strategy == "none" {
+    strategy = ""
+  }
+  if !internal.IsMergeStrategy(strategy) {
+    fmt.Printf("Unknown merge strategy %v, use one of: %v\n", strategy, strings.Join(internal.MergeStrategies(), ", "))
+    return
+  }
+
+  err = internal.SetMergeStrategy(f, strategy)
+  if err != nil {
+    log.Fatalf("Unable to set the merge strategy\n%v\n", err)
+  }
 }
 
-func printCommandHelp(command string) {
-  switch strings.ToLower(command) {
-    case "pull":
-      fmt.Println("Pulls the latest version for every file or a specific file from the vendor")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer pull")
-    case "push":
-      fmt.Println("Pushes the local version to the remote repository for the vendor")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer push")
-    case "browse":
-      fmt.Println("Browses or lists the remote file server")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer browse [vendor]")
-    case "auth":
-      fmt.Println("Authenticates for syncing to the cloud file management")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer auth")
-    case "status":
-      fmt.Println("Prints the current status of the daemon and files being watched")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer status")
-    case "start":
-      fmt.Println("Starts the syncer daemon")
-    case "stop":
-      fmt.Println("Stops the syncer daemon")
-    case "ls":
-      fmt.Println("Prints the files being watched")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer ls")
-    case "add":
-      fmt.Println("Adds the given file to be watched")
-      fmt.Println()
-      fmt.Println("Usage:")
-      fmt.Println()
-      fmt.Println("\tsyncer add <remote name> [local path]")
-      fmt.Println()
-      fmt.Println("Arguments:")
-      fmt.Println()
-      fmt.Println("\t- <remote name> should be a unique name together with the vendor. ")
-      fmt.Println("\t  I.e. every filename/filepath should be unique for the vendor. This is normal for any filesystem")
-      fmt.Println("\t- [local path] is an optional argument. The default is $HOME/syncer/$VENDORDIR/RemoteName")
-    case "rm":
-      fmt.Println("Removes the given file from syncing")
-      fmt.Println()
-      fmt.Println("Usage:")
+func handleInterval(ctx *Context) {
+  f, err := ctx.findFile(ctx.Args[0])
+  if err != nil {
+    log.Fatalf("%v\n", err)
+  }
+
+  if len(ctx.Args) < 2 {
+    if f.Interval == 0 {
+      fmt.Printf("%v is synced at the sync.interval setting\n", f.RemoteName)
+    } else {
+      fmt.Printf("%v is synced every %v\n", f.RemoteName, f.Interval)
+    }
+    return
+  }
+
+  var interval time.Duration
+  if ctx.Args[1] != "default" {
+    interval, err = internal.ParseInterval(ctx.Args[1])
+    if err != nil {
+      fmt.Printf("Invalid interval %v, use a duration like 30s or 5m, or default\n", ctx.Args[1])
+      return
+    }
+  }
+
+  err = internal.SetInterval(f, interval)
+  if err != nil {
+    log.Fatalf("Unable to set the interval\n%v\n", err)
+  }
+  reloadDaemon(ctx)
+}
+
+func handleHistory(ctx *Context) {
+  f, err := ctx.findFile(ctx.Args[0])
+  if err != nil {
+    log.Fatalf("%v\n", err)
+  }
+
+  snapshots, err := internal.Snapshots(f)
+  if err != nil {
+    log.Fatalf("Unable to get the history of %v\n%v\n", f.RemoteName, err)
+  }
+
+  if ctx.structured() {
+    list := make([]snapshotJSON, 0)
+    for _, s := range snapshots {
+      list = append(list, snapshotJSON{Version: s.Version, Time: s.Time, Size: s.Size, Path: s.Path})
+    }
+    ctx.output(list)
+    return
+  }
+
+  fmt.Printf("Versions of %v\n", f.RemoteName)
+  if len(snapshots) > 0 {
+    fmt.Println()
+  }
+  for _, s := range snapshots {
+    fmt.Printf("%v\t%v\t%v bytes\n", s.Version, s.Time.Format("2006-01-02 15:04:05"), s.Size)
+  }
+}
+
+func handleRestore(ctx *Context) {
+  f, err := ctx.findFile(ctx.Args[0])
+  if err != nil {
+    log.Fatalf("%v\n", err)
+  }
+
+  version, err := strconv.Atoi(ctx.Args[1])
+  if err != nil {
+    fmt.Printf("%v is not a version, use \"syncer history %v\" to list the versions\n", ctx.Args[1], f.RemoteName)
+    return
+  }
+
+  err = internal.Restore(f, version)
+  if err != nil {
+    log.Fatalf("Unable to restore %v\n%v\n", f.RemoteName, err)
+  }
+  fmt.Printf("Restored version %v of %v, it is pushed with the next sync\n", version, f.RemoteName)
+}
+
+func handleConfig(ctx *Context) {
+  args := ctx.Args
+  if len(args) < 1 {
+    settings, err := internal.Settings()
+    if err != nil {
+      log.Fatalf("Unable to get the settings\n%v\n", err)
+    }
+
+    keys := make([]string, 0)
+    for key := range settings {
+      keys = append(keys, key)
+    }
+    slices.Sort(keys)
+
+    if ctx.structured() {
+      ctx.output(settings)
+      return
+    }
+
+    for _, key := range keys {
+      fmt.Printf("%v = %v\n", key, settings[key])
+    }
+    return
+  }
+
+  key := args[0]
+  if !internal.IsSetting(key) {
+    fmt.Printf("Unknown setting %v\n", key)
+    fmt.Println("Use \"syncer config\" to list the settings")
+    return
+  }
+
+  if len(args) < 2 {
+    value, err := internal.GetSetting(key)
+    if err != nil {
+      log.Fatalf("Unable to get %v\n%v\n", key, err)
+    }
+    if ctx.structured() {
+      ctx.output(map[string]string{key: value})
+      return
+    }
+    fmt.Println(value)
+    return
+  }
+
+  if key == "sync.interval" {
+    _, err := internal.ParseInterval(args[1])
+    if err != nil {
+      fmt.Printf("Invalid interval %v, use a duration like 30s or 5m\n", args[1])
+      return
+    }
+  }
+
+  err := internal.SetSetting(key, args[1])
+  if err != nil {
+    log.Fatalf("Unable to set %v\n%v\n", key, err)
+  }
+  reloadDaemon(ctx)
+}
+
+func handleConflicts(ctx *Context) {
+  if len(ctx.Args) < 1 {
+    files, err := internal.GetFiles()
+    if err != nil {
+      log.Fatalf("Unable to get the watched files\n%v\n", err)
+    }
+
+    fmt.Println("Files with a conflict")
+    for _, f := range ctx.filter(files) {
+      if f.Status != internal.Conflict {
+        continue
+      }
       fmt.Println()
-      fmt.Println("\tsyncer rm <remote name>")
-    case "help":
-      fmt.Println("Prints the help")
+      fmt.Printf("%v (%v)\n", f.RemoteName, f.Account)
+      fmt.Printf("\tlocal:  %v\n", f.LocalPath)
+      fmt.Printf("\tremote: %v\n", f.ConflictPath)
+    }
+    return
+  }
+
+  if ctx.Has("merge") {
+    f, err := ctx.findFile(ctx.Args[0])
+    if err != nil {
+      log.Fatalf("%v\n", err)
+    }
+
+    options := internal.MergeOptions{
+      KeyFile: ctx.Flag("key-file"),
+    }
+    if !ctx.Has("no-password") {
+      options.Password, err = readPassword(fmt.Sprintf("Password for %v: ", f.RemoteName))
+      if err != nil {
+        log.Fatalf("Unable to read the password\n%v\n", err)
+      }
+    }
+
+    err = internal.Merge(f.Account.Repository(), &f, options)
+    if err != nil {
+      log.Fatalf("Unable to merge %v\n%v\n", f.RemoteName, err)
+    }
+    fmt.Printf("Merged %v\n", f.RemoteName)
+    return
+  }
+
+  resolutions := map[string]internal.Resolution{
+    "keep-local": internal.KeepLocal,
+    "keep-remote": internal.KeepRemote,
+    "keep-both": internal.KeepBoth,
+  }
+
+  var resolution internal.Resolution
+  count := 0
+  for flag, r := range resolutions {
+    if ctx.Has(flag) {
+      resolution = r
+      count++
+    }
+  }
+  if count != 1 {
+    fmt.Println("Use exactly one of --merge, --keep-local, --keep-remote or --keep-both")
+    fmt.Println("Use \"syncer help conflicts\" to see what arguments to use")
+    return
+  }
+
+  f, err := ctx.findFile(ctx.Args[0])
+  if err != nil {
+    log.Fatalf("%v\n", err)
+  }
+
+  err = internal.Resolve(f.Account.Repository(), &f, resolution)
+  if err != nil {
+    log.Fatalf("Unable to resolve the conflict of %v\n%v\n", f.RemoteName, err)
+  }
+}
+
+// filter keeps the files of the vendor and account given with --vendor and
+// --account, all files are kept without them
+func (ctx *Context) filter(files []internal.File) []internal.File {
+  filtered := make([]internal.File, 0)
+  for _, f := range files {
+    if ctx.Has("vendor") && f.Account.Vendor != ctx.Vendor {
+      continue
+    }
+    if ctx.Account != "" && f.Account.Name != ctx.Account {
+      continue
+    }
+    filtered = append(filtered, f)
+  }
+
+  return filtered
+}
+
+// findFile returns the watched file with the remote name, searching only the
+// files of the account given with --account
+func (ctx *Context) findFile(remoteName string) (internal.File, error) {
+  files, err := internal.GetFiles()
+  if err != nil {
+    return internal.File{}, err
+  }
+
+  matches := make([]internal.File, 0)
+  for _, f := range ctx.filter(files) {
+    if f.RemoteName == remoteName {
+      matches = append(matches, f)
+    }
+  }
+
+  switch len(matches) {
+    case 0:
+      return internal.File{}, fmt.Errorf("%v is not being watched", remoteName)
+    case 1:
+      ctx.verbosef("Found %v in %v at %v\n", remoteName, matches[0].Account, matches[0].LocalPath)
+      return matches[0], nil
     default:
-      fmt.Println("Unknown command")
+      return internal.File{}, fmt.Errorf("%v is watched in several accounts, choose one with --account <name>", remoteName)
+  }
+}
+
+// accountFlag returns the flag that selects the account on the command line
+func accountFlag(account internal.Account) string {
+  if account.Name == internal.DefaultAccount {
+    return ""
+  }
+  return " --account " + account.Name
+}
+
+func readPassword(prompt string) (string, error) {
+  fmt.Print(prompt)
+
+  stty := exec.Command("stty", "-echo")
+  stty.Stdin = os.Stdin
+  err := stty.Run()
+  if err == nil {
+    defer func() {
+      stty := exec.Command("stty", "echo")
+      stty.Stdin = os.Stdin
+      stty.Run()
+      fmt.Println()
+    }()
   }
+
+  reader := bufio.NewReader(os.Stdin)
+  password, err := reader.ReadString('\n')
+  if err != nil && password == "" {
+    return "", err
+  }
+
+  return strings.TrimRight(password, "\r\n"), nil
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"syncer/internal"
+)
+
+func TestSelectFiles(t *testing.T) {
+  dir := t.TempDir()
+  t.Chdir(dir)
+
+  files := []internal.File{
+    {Id: 1, RemoteName: "notes.txt", LocalPath: filepath.Join(dir, "notes.txt")},
+    {Id: 2, RemoteName: "todo.txt", LocalPath: filepath.Join(dir, "lists", "todo")},
+    {Id: 3, RemoteName: "passwords.kdbx", LocalPath: "/home/user/passwords.kdbx"},
+  }
+
+  tests := []struct {
+    name string
+    patterns []string
+    ids []int
+    err bool
+  }{
+    {name: "no patterns", patterns: nil, ids: []int{1, 2, 3}},
+    {name: "remote name", patterns: []string{"todo.txt"}, ids: []int{2}},
+    {name: "remote name glob", patterns: []string{"*.txt"}, ids: []int{1, 2}},
+    {name: "local path glob", patterns: []string{"/home/*/*.kdbx"}, ids: []int{3}},
+    {name: "relative local path", patterns: []string{filepath.Join("lists", "todo")}, ids: []int{2}},
+    {name: "relative local path glob", patterns: []string{filepath.Join("lists", "*")}, ids: []int{2}},
+    {name: "matched twice", patterns: []string{"notes.txt", "*.txt"}, ids: []int{1, 2}},
+    {name: "no match", patterns: []string{"*.txt", "missing"}, err: true},
+    {name: "invalid pattern", patterns: []string{"[notes"}, err: true},
+  }
+
+  for _, test := range tests {
+    selected, err := selectFiles(files, test.patterns)
+    if test.err {
+      if err == nil {
+        t.Errorf("%v: selected %v, want an error", test.name, fileIds(selected))
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%v: %v", test.name, err)
+      continue
+    }
+    if ids := fileIds(selected); !equalIds(ids, test.ids) {
+      t.Errorf("%v: selected %v, want %v", test.name, ids, test.ids)
+    }
+  }
+}
+
+func TestSyncOptions(t *testing.T) {
+  tests := []struct {
+    args []string
+    push bool
+    options internal.SyncOptions
+  }{
+    {[]string{"pull"}, false, internal.SyncOptions{}},
+    {[]string{"pull", "--force", "notes.txt"}, false, internal.SyncOptions{Force: true}},
+    {[]string{"push"}, true, internal.SyncOptions{Push: true}},
+    {[]string{"push", "notes.txt", "--force"}, true, internal.SyncOptions{Push: true, Force: true}},
+  }
+
+  for _, test := range tests {
+    ctx, err := parse(test.args)
+    if err != nil {
+      t.Errorf("%v: %v", test.args, err)
+      continue
+    }
+    if options := syncOptions(ctx, test.push); options != test.options {
+      t.Errorf("%v: options = %+v, want %+v", test.args, options, test.options)
+    }
+  }
+}
+
+func TestCountFailed(t *testing.T) {
+  results := []syncJSON{
+    {Result: internal.Pulled},
+    {Result: internal.Pushed},
+    {Result: internal.UpToDate},
+    {Result: internal.PushFirst},
+    {Result: internal.InConflict},
+    {Result: internal.Skipped},
+    {Result: internal.Failed},
+    {Result: internal.PullFirst},
+  }
+
+  if failed := countFailed(results); failed != 2 {
+    t.Errorf("failed = %v, want 2", failed)
+  }
+}
+
+func TestSyncFilesExitCode(t *testing.T) {
+  // No daemon is listening in an empty runtime directory
+  t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+  internal.SetConfigDir(t.TempDir())
+  defer internal.SetConfigDir("")
+  err := internal.Init()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  account, err := internal.AddAccount(internal.GoogleDrive, internal.DefaultAccount)
+  if err != nil {
+    t.Fatal(err)
+  }
+  dir := t.TempDir()
+  for _, name := range []string{"paused.txt", "conflict.txt"} {
+    err = internal.AddFile(internal.File{RemoteId: name, RemoteName: name, LocalPath: filepath.Join(dir, name), Account: account})
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  // The files are never synced, so no request reaches the vendor
+  files, err := internal.GetFiles()
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, f := range files {
+    switch f.RemoteName {
+      case "paused.txt":
+        f.Status = internal.Paused
+      case "conflict.txt":
+        f.Status = internal.Conflict
+    }
+    err = internal.UpdateFile(f)
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  tests := []struct {
+    args []string
+    push bool
+    code int
+  }{
+    {[]string{"pull", "paused.txt"}, false, 1},
+    {[]string{"pull", "conflict.txt"}, false, 1},
+    {[]string{"pull", "--force", "conflict.txt"}, false, 1},
+    {[]string{"push", "--force", "conflict.txt"}, true, 1},
+    {[]string{"push", "missing.txt"}, true, 1},
+    {[]string{"push", "--json", "paused.txt"}, true, 1},
+    {[]string{"pull", "--account", "other"}, false, 0},
+  }
+
+  for _, test := range tests {
+    ctx, err := parse(test.args)
+    if err != nil {
+      t.Errorf("%v: %v", test.args, err)
+      continue
+    }
+    if code := syncFiles(ctx, test.push); code != test.code {
+      t.Errorf("%v: exit code = %v, want %v", test.args, code, test.code)
+    }
+  }
+}
+
+func fileIds(files []internal.File) []int {
+  ids := make([]int, 0)
+  for _, f := range files {
+    ids = append(ids, f.Id)
+  }
+  return ids
+}
+
+func equalIds(a []int, b []int) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for i := range a {
+    if a[i] != b[i] {
+      return false
+    }
+  }
+  return true
+}
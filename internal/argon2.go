@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// golang.org/x/crypto/argon2 only provides Argon2i and Argon2id, while
+// KeePass databases use Argon2d by default. This is a plain implementation
+// of Argon2 version 1.3 (RFC 9106) for the modes KDBX 4 supports.
+
+const (
+  argon2d = 0
+  argon2id = 2
+
+  argon2BlockWords = 128
+  argon2SyncPoints = 4
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+func argon2Key(mode int, password []byte, salt []byte, passes uint32, memory uint32, lanes uint32, keyLen uint32) []byte {
+  return argon2Derive(mode, password, salt, nil, nil, passes, memory, lanes, keyLen)
+}
+
+// argon2Derive is argon2Key with the optional secret and associated data,
+// which KDBX never uses but the test vectors of RFC 9106 do
+func argon2Derive(mode int, password []byte, salt []byte, secret []byte, ad []byte, passes uint32, memory uint32, lanes uint32, keyLen uint32) []byte {
+  h, _ := blake2b.New512(nil)
+  for _, v := range []uint32{lanes, keyLen, memory, passes, 0x13, uint32(mode), uint32(len(password))} {
+    h.Write(le32(v))
+  }
+  h.Write(password)
+  h.Write(le32(uint32(len(salt))))
+  h.Write(salt)
+  h.Write(le32(uint32(len(secret))))
+  h.Write(secret)
+  h.Write(le32(uint32(len(ad))))
+  h.Write(ad)
+  h0 := h.Sum(nil)
+
+  // Round down to a multiple of 4 * lanes
+  memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+  if memory < 2 * argon2SyncPoints * lanes {
+    memory = 2 * argon2SyncPoints * lanes
+  }
+  columns := memory / lanes
+  segment := columns / argon2SyncPoints
+
+  blocks := make([]argon2Block, memory)
+  for lane := uint32(0); lane < lanes; lane++ {
+    for i := uint32(0); i < 2; i++ {
+      input := append(append(append([]byte{}, h0...), le32(i)...), le32(lane)...)
+      data := argon2Hash(input, 1024)
+      for w := range blocks[lane * columns + i] {
+        blocks[lane * columns + i][w] = binary.LittleEndian.Uint64(data[w * 8:])
+      }
+    }
+  }
+
+  for pass := uint32(0); pass < passes; pass++ {
+    for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+      var wg sync.WaitGroup
+      for lane := uint32(0); lane < lanes; lane++ {
+        wg.Add(1)
+        go func(lane uint32) {
+          defer wg.Done()
+          argon2Segment(blocks, mode, pass, slice, lane, passes, memory, lanes, columns, segment)
+        }(lane)
+      }
+      wg.Wait()
+    }
+  }
+
+  final := blocks[columns - 1]
+  for lane := uint32(1); lane < lanes; lane++ {
+    for w, v := range blocks[lane * columns + columns - 1] {
+      final[w] ^= v
+    }
+  }
+
+  data := make([]byte, 1024)
+  for w, v := range final {
+    binary.LittleEndian.PutUint64(data[w * 8:], v)
+  }
+
+  return argon2Hash(data, keyLen)
+}
+
+func argon2Segment(blocks []argon2Block, mode int, pass, slice, lane, passes, memory, lanes, columns, segment uint32) {
+  independent := mode == argon2id && pass == 0 && slice < argon2SyncPoints / 2
+
+  var address, input, zero argon2Block
+  if independent {
+    input[0] = uint64(pass)
+    input[1] = uint64(lane)
+    input[2] = uint64(slice)
+    input[3] = uint64(memory)
+    input[4] = uint64(passes)
+    input[5] = uint64(mode)
+  }
+  nextAddresses := func() {
+    input[6]++
+    argon2Compress(&address, &zero, &input, false)
+    argon2Compress(&address, &zero, &address, false)
+  }
+
+  start := uint32(0)
+  if pass == 0 && slice == 0 {
+    start = 2
+  }
+  if independent {
+    nextAddresses()
+  }
+
+  for index := start; index < segment; index++ {
+    column := slice * segment + index
+    current := lane * columns + column
+    previous := current - 1
+    if column == 0 {
+      previous = lane * columns + columns - 1
+    }
+
+    var random uint64
+    if independent {
+      if index % argon2BlockWords == 0 && index != start {
+        nextAddresses()
+      }
+      random = address[index % argon2BlockWords]
+    } else {
+      random = blocks[previous][0]
+    }
+
+    refLane := uint32(random >> 32) % lanes
+    if pass == 0 && slice == 0 {
+      refLane = lane
+    }
+
+    // Number of blocks that can be referenced
+    var area uint32
+    if pass == 0 {
+      area = slice * segment
+    } else {
+      area = columns - segment
+    }
+    if refLane == lane {
+      area += index - 1
+    } else if index == 0 {
+      area--
+    }
+
+    x := (random & 0xffffffff) * (random & 0xffffffff) >> 32
+    relative := uint64(area) - 1 - (uint64(area) * x >> 32)
+
+    startPosition := uint32(0)
+    if pass != 0 && slice != argon2SyncPoints - 1 {
+      startPosition = (slice + 1) * segment
+    }
+    refColumn := uint32((uint64(startPosition) + relative) % uint64(columns))
+
+    argon2Compress(&blocks[current], &blocks[previous], &blocks[refLane * columns + refColumn], pass > 0)
+  }
+}
+
+// argon2Compress is the compression function G, with xor the result is
+// combined with the existing content of out as version 1.3 requires
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+  var r, z argon2Block
+  for i := range r {
+    r[i] = x[i] ^ y[i]
+  }
+  z = r
+
+  for i := 0; i < argon2BlockWords; i += 16 {
+    blamka(&z[i], &z[i+1], &z[i+2], &z[i+3], &z[i+4], &z[i+5], &z[i+6], &z[i+7],
+      &z[i+8], &z[i+9], &z[i+10], &z[i+11], &z[i+12], &z[i+13], &z[i+14], &z[i+15])
+  }
+  for i := 0; i < 16; i += 2 {
+    blamka(&z[i], &z[i+1], &z[i+16], &z[i+17], &z[i+32], &z[i+33], &z[i+48], &z[i+49],
+      &z[i+64], &z[i+65], &z[i+80], &z[i+81], &z[i+96], &z[i+97], &z[i+112], &z[i+113])
+  }
+
+  for i := range z {
+    if xor {
+      out[i] ^= z[i] ^ r[i]
+    } else {
+      out[i] = z[i] ^ r[i]
+    }
+  }
+}
+
+func blamka(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+  blamkaG(v0, v4, v8, v12)
+  blamkaG(v1, v5, v9, v13)
+  blamkaG(v2, v6, v10, v14)
+  blamkaG(v3, v7, v11, v15)
+  blamkaG(v0, v5, v10, v15)
+  blamkaG(v1, v6, v11, v12)
+  blamkaG(v2, v7, v8, v13)
+  blamkaG(v3, v4, v9, v14)
+}
+
+func blamkaG(a, b, c, d *uint64) {
+  *a += *b + 2 * uint64(uint32(*a)) * uint64(uint32(*b))
+  *d = bits.RotateLeft64(*d ^ *a, -32)
+  *c += *d + 2 * uint64(uint32(*c)) * uint64(uint32(*d))
+  *b = bits.RotateLeft64(*b ^ *c, -24)
+  *a += *b + 2 * uint64(uint32(*a)) * uint64(uint32(*b))
+  *d = bits.RotateLeft64(*d ^ *a, -16)
+  *c += *d + 2 * uint64(uint32(*c)) * uint64(uint32(*d))
+  *b = bits.RotateLeft64(*b ^ *c, -63)
+}
+
+// argon2Hash is the variable length hash function H'
+func argon2Hash(input []byte, size uint32) []byte {
+  if size <= blake2b.Size {
+    h, _ := blake2b.New(int(size), nil)
+    h.Write(le32(size))
+    h.Write(input)
+    return h.Sum(nil)
+  }
+
+  r := (size + 31) / 32 - 2
+  out := make([]byte, 0, size)
+
+  h, _ := blake2b.New512(nil)
+  h.Write(le32(size))
+  h.Write(input)
+  v := h.Sum(nil)
+  out = append(out, v[:32]...)
+
+  for i := uint32(1); i < r; i++ {
+    sum := blake2b.Sum512(v)
+    v = sum[:]
+    out = append(out, v[:32]...)
+  }
+
+  last, _ := blake2b.New(int(size - 32 * r), nil)
+  last.Write(v)
+
+  return last.Sum(out)
+}
+
+func le32(v uint32) []byte {
+  b := make([]byte, 4)
+  binary.LittleEndian.PutUint32(b, v)
+  return b
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestArgon2idMatchesXCrypto(t *testing.T) {
+  tests := []struct {
+    passes uint32
+    memory uint32
+    lanes uint32
+    keyLen uint32
+  }{
+    {1, 64, 1, 32},
+    {3, 32, 4, 32},
+    {2, 100, 3, 16},
+    {1, 256, 2, 80},
+  }
+
+  for _, test := range tests {
+    password := []byte("correct horse battery staple")
+    salt := []byte("0123456789abcdef")
+
+    got := argon2Key(argon2id, password, salt, test.passes, test.memory, test.lanes, test.keyLen)
+    want := argon2.IDKey(password, salt, test.passes, test.memory, uint8(test.lanes), test.keyLen)
+    if !bytes.Equal(got, want) {
+      t.Errorf("argon2id(t=%v, m=%v, p=%v, len=%v) = %x, want %x", test.passes, test.memory, test.lanes, test.keyLen, got, want)
+    }
+  }
+}
+
+// The Argon2d and Argon2id test vectors of RFC 9106 section 5
+func TestArgon2RFC9106(t *testing.T) {
+  tests := []struct {
+    name string
+    mode int
+    tag string
+  }{
+    {"argon2d", argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+    {"argon2id", argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+  }
+
+  password := bytes.Repeat([]byte{0x01}, 32)
+  salt := bytes.Repeat([]byte{0x02}, 16)
+  secret := bytes.Repeat([]byte{0x03}, 8)
+  ad := bytes.Repeat([]byte{0x04}, 12)
+
+  for _, test := range tests {
+    got := argon2Derive(test.mode, password, salt, secret, ad, 3, 32, 4, 32)
+    if hex.EncodeToString(got) != test.tag {
+      t.Errorf("%v = %x, want %v", test.name, got, test.tag)
+    }
+  }
+}
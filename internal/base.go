@@ -1,23 +1,49 @@
 package internal
 
 import (
-	"os/user"
-	"path/filepath"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Status int
 const (
   Error Status = iota
   Synced
+  Conflict
+  NeedsReauth
+  Paused
 )
 var statusString = map[Status]string{
   Error: "Error",
   Synced: "Synced",
+  Conflict: "Conflict",
+  NeedsReauth: "Needs reauthentication",
+  Paused: "Paused",
 }
 func (status Status) String() string{
   return statusString[status]
 }
 
+type AuthState int
+const (
+  Unauthenticated AuthState = iota
+  Authenticated
+  Reauthenticate
+  AuthUnknown
+)
+var authStateString = map[AuthState]string{
+  Unauthenticated: "not authenticated",
+  Authenticated: "authenticated",
+  Reauthenticate: "in need of reauthentication",
+  AuthUnknown: "unverified",
+}
+func (state AuthState) String() string {
+  return authStateString[state]
+}
+
 type Vendor int
 const (
   GoogleDrive Vendor = iota
@@ -26,21 +52,106 @@ const (
 var vendorString = map[Vendor]string{
   GoogleDrive: "Google Drive",
 }
-func (vendor Vendor) Repository() Repository {
-  u, _ := user.Current()
+func (vendor Vendor) String() string {
+  return vendorString[vendor]
+}
+
+// vendorNames are the names of the vendors on the command line
+var vendorNames = map[string]Vendor{
+  "google-drive": GoogleDrive,
+  "google": GoogleDrive,
+  "gdrive": GoogleDrive,
+}
+
+// ParseVendor returns the vendor with the name used on the command line
+func ParseVendor(name string) (Vendor, error) {
+  vendor, ok := vendorNames[strings.ToLower(name)]
+  if !ok {
+    return 0, fmt.Errorf("unknown vendor %v, use one of: %v", name, strings.Join(Vendors(), ", "))
+  }
+  return vendor, nil
+}
+
+// Vendors returns the main name of every supported vendor
+func Vendors() []string {
+  return []string{"google-drive"}
+}
+
+// DefaultAccount is the account used when none is given
+const DefaultAccount = "default"
+
+// Account is a login at a vendor, a vendor can have several accounts
+type Account struct {
+  Id int
+  Vendor Vendor
+  Name string
+  // Credential is the name of the token in the credential store
+  Credential string
+}
+
+func (account Account) String() string {
+  if account.Name == DefaultAccount {
+    return account.Vendor.String()
+  }
+  return fmt.Sprintf("%v: %v", account.Vendor, account.Name)
+}
+
+var repositories = make(map[int]Repository)
+var repositoriesCtx context.Context
+var repositoriesMu sync.Mutex
+
+// Repository returns the repository of the account, which is created once
+// and shared by every caller
+func (account Account) Repository() Repository {
+  repositoriesMu.Lock()
+  defer repositoriesMu.Unlock()
 
-  switch vendor {
+  if repo, ok := repositories[account.Id]; ok {
+    return repo
+  }
+
+  var repo Repository
+  switch account.Vendor {
     case GoogleDrive:
-      p := filepath.Join(u.HomeDir, ".config/syncer", "google_token.json")
-      return Google{
-        TokenPath: p,
-      }
+      repo = newGoogle(account)
     default:
       return nil
   }
+  if repositoriesCtx != nil {
+    repo.SetContext(repositoriesCtx)
+  }
+  repositories[account.Id] = repo
+
+  return repo
 }
-func (vendor Vendor) String() string {
-  return vendorString[vendor]
+
+// SetContext sets the context of the repositories of all accounts,
+// including the ones that are created later
+func SetContext(ctx context.Context) {
+  repositoriesMu.Lock()
+  defer repositoriesMu.Unlock()
+
+  repositoriesCtx = ctx
+  for _, repo := range repositories {
+    repo.SetContext(ctx)
+  }
+}
+
+// ResetRepositories drops the repositories of all accounts, so they are
+// created again with the current credentials and settings
+func ResetRepositories() {
+  repositoriesMu.Lock()
+  defer repositoriesMu.Unlock()
+
+  repositories = make(map[int]Repository)
+}
+
+// accountSuffix is appended to the names of the files of an account
+func accountSuffix(name string) string {
+  if name == DefaultAccount {
+    return ""
+  }
+  return "_" + name
 }
 
 type File = struct {
@@ -48,18 +159,54 @@ type File = struct {
   RemoteId string
   Status Status
   Vendor Vendor
+  Account Account
   RemoteName string
   LocalPath string
   LastPulled string
+  Hash string
+  Revision string
+  ConflictPath string
+  Merge string
+  // Interval is how often the file is synced, 0 uses the sync.interval
+  // setting
+  Interval time.Duration
+  // PullNeeded is set when the remote file changed and wasn't pulled since
+  PullNeeded bool
+}
+
+// AuthOptions selects how an account is authenticated
+type AuthOptions struct {
+  // Device uses the device flow, for machines without a browser
+  Device bool
+  // ServiceAccount is the key file of a service account to use instead of
+  // an interactive login
+  ServiceAccount string
+  // Subject is the user the service account acts as, through domain-wide
+  // delegation
+  Subject string
+}
+
+// RemoteFile is a file in the cloud that can be added
+type RemoteFile struct {
+  Id string
+  Name string
 }
 
 type Repository interface {
+  SetContext(ctx context.Context)
+  Stat(file *File) (Remote, error)
+  Download(file *File, path string) (Remote, error)
+  Upload(file *File) (Remote, error)
+  Changes() ([]string, error)
+  Watch(address string, channel Channel) (Channel, error)
+  Unwatch(channel Channel) error
   Pull(file *File) error
-  PullAll(files []File) error
   Push(file *File) error
-  PushAll(files []File) error
   GetRemoteId(name string) (string, error)
-  List() ([]string, error)
-  Authenticate() error
+  List() ([]RemoteFile, error)
+  Authenticate(options AuthOptions) error
+  SetClientSecret(path string) error
+  Revoke() error
   IsAuthenticated() bool
+  AuthState() AuthState
 }
@@ -2,10 +2,15 @@ package internal
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -14,9 +19,37 @@ const (
   configPath = "/.config/syncer/"
 )
 var db string
+var settingDefaults = map[string]string{
+  "history.versions": "10",
+  "history.days": "30",
+  "google.webhook": "",
+  "google.listen": "127.0.0.1:8765",
+  "credentials.command": "pass",
+  "sync.interval": "30s",
+}
+var configDir string
+// configOverride replaces the default config directory when set
+var configOverride string
+var accountName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var ErrUnknownAccount = errors.New("unknown account")
+
+// SetConfigDir uses dir instead of ~/.config/syncer for the database and
+// the credentials, it has to be called before Init
+func SetConfigDir(dir string) {
+  configOverride = dir
+}
+
+// ConfigDir returns the directory of the database and the credentials
+func ConfigDir() string {
+  return configDir
+}
 
 func Init() error {
-  ensureDatabasePathExists()
+  err := ensureDatabasePathExists()
+  if err != nil {
+    return err
+  }
 
   db, err := sql.Open("sqlite3", db)
   if err != nil {
@@ -29,6 +62,11 @@ func Init() error {
     return err
   }
 
+  err = migrateTables(db)
+  if err != nil {
+    return err
+  }
+
   return nil
 }
 
@@ -41,14 +79,25 @@ func GetFiles() ([]File, error) {
   }
   defer db.Close()
 
-  rows, err := db.Query(`SELECT   id,
-                                  remoteid,
-                                  remotename, 
-                                  localpath, 
-                                  status, 
-                                  vendor, 
-                                  lastpulled 
-                         FROM     files`)
+  rows, err := db.Query(`SELECT   f.id,
+                                  f.remoteid,
+                                  f.remotename, 
+                                  f.localpath, 
+                                  f.status, 
+                                  f.vendor, 
+                                  f.lastpulled,
+                                  f.hash,
+                                  f.revision,
+                                  f.conflictpath,
+                                  f.merge,
+                                  f.interval,
+                                  f.pullneeded,
+                                  a.id,
+                                  a.vendor,
+                                  a.name,
+                                  a.credential
+                         FROM     files f
+                         JOIN     accounts a ON a.id = f.account`)
   if err != nil {
     return nil, err
   }
@@ -62,8 +111,16 @@ func GetFiles() ([]File, error) {
     var status Status
     var vendor Vendor
     var lastpulled string
-
-    err = rows.Scan(&id, &remoteid, &remotename, &localpath, &status, &vendor, &lastpulled)
+    var hash string
+    var revision string
+    var conflictpath string
+    var merge string
+    var interval int
+    var pullneeded bool
+    var account Account
+
+    err = rows.Scan(&id, &remoteid, &remotename, &localpath, &status, &vendor, &lastpulled, &hash, &revision, &conflictpath, &merge, &interval, &pullneeded,
+                    &account.Id, &account.Vendor, &account.Name, &account.Credential)
     if err != nil {
       continue
     }
@@ -75,7 +132,14 @@ func GetFiles() ([]File, error) {
       LocalPath: localpath,
       Status: status,
       Vendor: vendor,
+      Account: account,
       LastPulled: lastpulled,
+      Hash: hash,
+      Revision: revision,
+      ConflictPath: conflictpath,
+      Merge: merge,
+      Interval: time.Duration(interval) * time.Second,
+      PullNeeded: pullneeded,
     }
 
     files = append(files, f)
@@ -91,13 +155,19 @@ func UpdateFile(file File) error {
   }
   defer db.Close()
 
-  stmt, err := db.Prepare("UPDATE files SET status = ?, lastpulled = CURRENT_TIMESTAMP WHERE id = ?")
+  stmt, err := db.Prepare(`UPDATE files 
+                           SET    status = ?, 
+                                  hash = ?, 
+                                  revision = ?, 
+                                  conflictpath = ?, 
+                                  lastpulled = CURRENT_TIMESTAMP 
+                           WHERE  id = ?`)
   if err != nil {
     return err
   }
   defer stmt.Close()
 
-  _, err = stmt.Exec(file.Status, file.Id)
+  _, err = stmt.Exec(file.Status, file.Hash, file.Revision, file.ConflictPath, file.Id)
   if err != nil {
     fmt.Printf("Error: %v\n", err)
     return err
@@ -113,14 +183,14 @@ func AddFile(file File) error {
   }
   defer db.Close()
 
-  stmt, err := db.Prepare(`INSERT INTO files(remoteid, remotename, localpath, status, vendor, lastpulled) 
-                           VALUES (?, ?, ?, ?, ?, '2000-01-01 00:00:00')`)
+  stmt, err := db.Prepare(`INSERT INTO files(remoteid, remotename, localpath, status, vendor, account, merge, interval, lastpulled) 
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?, '2000-01-01 00:00:00')`)
   if err != nil {
     return err
   }
   defer stmt.Close()
 
-  _, err = stmt.Exec(file.RemoteId, file.RemoteName, file.LocalPath, Error, file.Vendor)
+  _, err = stmt.Exec(file.RemoteId, file.RemoteName, file.LocalPath, Error, file.Account.Vendor, file.Account.Id, file.Merge, int(file.Interval / time.Second))
   if err != nil {
     fmt.Printf("Error: %v\n", err)
     return err
@@ -129,19 +199,30 @@ func AddFile(file File) error {
   return nil
 }
 
-func RemoveFile(remoteName string) error {
+// PauseFiles stops syncing the files of the account until ResumeFiles
+func PauseFiles(account Account) error {
+  return setAccountStatus(account, Paused, "status != ?", Conflict)
+}
+
+// ResumeFiles syncs the paused files of the account again
+func ResumeFiles(account Account) error {
+  return setAccountStatus(account, Error, "status = ?", Paused)
+}
+
+func setAccountStatus(account Account, status Status, condition string, arg any) error {
   db, err := sql.Open("sqlite3", db)
   if err != nil {
     return err
   }
   defer db.Close()
 
-  stmt, err := db.Prepare("DELETE FROM files WHERE remotename = ?")
+  stmt, err := db.Prepare("UPDATE files SET status = ? WHERE account = ? AND " + condition)
   if err != nil {
     return err
   }
   defer stmt.Close()
-  _, err = stmt.Exec(remoteName)
+
+  _, err = stmt.Exec(status, account.Id, arg)
   if err != nil {
     return err
   }
@@ -149,8 +230,340 @@ func RemoveFile(remoteName string) error {
   return nil
 }
 
-func createTables(db *sql.DB) error {
-  query := `CREATE TABLE IF NOT EXISTS files (
+func SetMergeStrategy(file File, strategy string) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  stmt, err := db.Prepare("UPDATE files SET merge = ? WHERE id = ?")
+  if err != nil {
+    return err
+  }
+  defer stmt.Close()
+
+  _, err = stmt.Exec(strategy, file.Id)
+  if err != nil {
+    return err
+  }
+
+  return nil
+}
+
+// markPullNeeded records that the remote files of the account changed, they
+// are pulled on the next sync even when the daemon stops before it
+func markPullNeeded(accountId int, remoteIds []string) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  tx, err := db.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  stmt, err := tx.Prepare("UPDATE files SET pullneeded = 1 WHERE account = ? AND remoteid = ?")
+  if err != nil {
+    return err
+  }
+  defer stmt.Close()
+
+  for _, id := range remoteIds {
+    _, err = stmt.Exec(accountId, id)
+    if err != nil {
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+// markAllPullNeeded records that any file of the account might have
+// changed, when the remote changes are unknown
+func markAllPullNeeded(accountId int) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  _, err = db.Exec("UPDATE files SET pullneeded = 1 WHERE account = ?", accountId)
+  if err != nil {
+    return err
+  }
+
+  return nil
+}
+
+// setPullNeeded records if the remote version of the file has to be pulled,
+// UpdateFile leaves it as it is
+func setPullNeeded(file *File, needed bool) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  _, err = db.Exec("UPDATE files SET pullneeded = ? WHERE id = ?", needed, file.Id)
+  if err != nil {
+    return err
+  }
+
+  file.PullNeeded = needed
+  return nil
+}
+
+// SetInterval sets how often the file is synced, 0 uses the sync.interval
+// setting
+func SetInterval(file File, interval time.Duration) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  stmt, err := db.Prepare("UPDATE files SET interval = ? WHERE id = ?")
+  if err != nil {
+    return err
+  }
+  defer stmt.Close()
+
+  _, err = stmt.Exec(int(interval / time.Second), file.Id)
+  if err != nil {
+    return err
+  }
+
+  return nil
+}
+
+func RemoveFile(file File) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  stmt, err := db.Prepare("DELETE FROM files WHERE id = ?")
+  if err != nil {
+    return err
+  }
+  defer stmt.Close()
+  _, err = stmt.Exec(file.Id)
+  if err != nil {
+    return err
+  }
+
+  removeBase(file)
+  removeHistory(file)
+
+  return nil
+}
+
+// GetAccounts returns the accounts of all vendors
+func GetAccounts() ([]Account, error) {
+  accounts := make([]Account, 0)
+
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return nil, err
+  }
+  defer db.Close()
+
+  rows, err := db.Query("SELECT id, vendor, name, credential FROM accounts ORDER BY vendor, id")
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    var a Account
+    err = rows.Scan(&a.Id, &a.Vendor, &a.Name, &a.Credential)
+    if err != nil {
+      return nil, err
+    }
+    accounts = append(accounts, a)
+  }
+
+  return accounts, rows.Err()
+}
+
+// GetAccount returns the account of the vendor with the name, an empty name
+// is the default account, which is created when needed
+func GetAccount(vendor Vendor, name string) (Account, error) {
+  if name == "" || name == DefaultAccount {
+    return AddAccount(vendor, DefaultAccount)
+  }
+
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return Account{}, err
+  }
+  defer db.Close()
+
+  a := Account{}
+  err = db.QueryRow("SELECT id, vendor, name, credential FROM accounts WHERE vendor = ? AND name = ?", vendor, name).
+    Scan(&a.Id, &a.Vendor, &a.Name, &a.Credential)
+  if err == sql.ErrNoRows {
+    return Account{}, fmt.Errorf("%w: %v, use \"syncer auth --account %v\" to add it", ErrUnknownAccount, name, name)
+  }
+  if err != nil {
+    return Account{}, err
+  }
+
+  return a, nil
+}
+
+// AddAccount adds an account to the vendor, an existing account with the
+// name is returned as it is
+func AddAccount(vendor Vendor, name string) (Account, error) {
+  if !accountName.MatchString(name) {
+    return Account{}, fmt.Errorf("invalid account name %v, use letters, digits, - and _", name)
+  }
+
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return Account{}, err
+  }
+  defer db.Close()
+
+  return addAccount(db, vendor, name)
+}
+
+func addAccount(db *sql.DB, vendor Vendor, name string) (Account, error) {
+  var credential string
+  switch vendor {
+    case GoogleDrive:
+      credential = googleCredential(name)
+    default:
+      return Account{}, fmt.Errorf("%v is not supported", vendor)
+  }
+
+  _, err := db.Exec("INSERT OR IGNORE INTO accounts(vendor, name, credential) VALUES (?, ?, ?)", vendor, name, credential)
+  if err != nil {
+    return Account{}, err
+  }
+
+  a := Account{}
+  err = db.QueryRow("SELECT id, vendor, name, credential FROM accounts WHERE vendor = ? AND name = ?", vendor, name).
+    Scan(&a.Id, &a.Vendor, &a.Name, &a.Credential)
+  if err != nil {
+    return Account{}, err
+  }
+
+  return a, nil
+}
+
+// GetSetting returns the value of the setting, or its default when it was
+// never set
+func GetSetting(key string) (string, error) {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return "", err
+  }
+  defer db.Close()
+
+  var value string
+  err = db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+  if err == sql.ErrNoRows {
+    return settingDefaults[key], nil
+  }
+  if err != nil {
+    return "", err
+  }
+
+  return value, nil
+}
+
+// GetIntSetting returns the value of a numeric setting
+func GetIntSetting(key string) (int, error) {
+  value, err := GetSetting(key)
+  if err != nil {
+    return 0, err
+  }
+
+  i, err := strconv.Atoi(value)
+  if err != nil {
+    return 0, fmt.Errorf("setting %v is not a number: %v", key, value)
+  }
+
+  return i, nil
+}
+
+// GetDurationSetting returns a setting like "30s" or "5m" as a duration
+func GetDurationSetting(key string) (time.Duration, error) {
+  value, err := GetSetting(key)
+  if err != nil {
+    return 0, err
+  }
+
+  d, err := ParseInterval(value)
+  if err != nil {
+    return 0, fmt.Errorf("setting %v is not a duration: %v", key, value)
+  }
+
+  return d, nil
+}
+
+// ParseInterval parses a sync interval like "30s" or "5m", an interval is
+// at least a second
+func ParseInterval(value string) (time.Duration, error) {
+  d, err := time.ParseDuration(value)
+  if err != nil {
+    return 0, err
+  }
+  if d < time.Second {
+    return 0, fmt.Errorf("%v is shorter than a second", value)
+  }
+
+  return d.Round(time.Second), nil
+}
+
+// Settings returns every known setting with its current value
+func Settings() (map[string]string, error) {
+  settings := make(map[string]string)
+  for key := range settingDefaults {
+    value, err := GetSetting(key)
+    if err != nil {
+      return nil, err
+    }
+    settings[key] = value
+  }
+
+  return settings, nil
+}
+
+func IsSetting(key string) bool {
+  _, ok := settingDefaults[key]
+  return ok
+}
+
+func SetSetting(key string, value string) error {
+  db, err := sql.Open("sqlite3", db)
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+
+  stmt, err := db.Prepare(`INSERT INTO settings(key, value) VALUES (?, ?)
+                           ON CONFLICT(key) DO UPDATE SET value = excluded.value`)
+  if err != nil {
+    return err
+  }
+  defer stmt.Close()
+
+  _, err = stmt.Exec(key, value)
+  if err != nil {
+    return err
+  }
+
+  return nil
+}
+
+const filesSchema = `(
             id INTEGER PRIMARY KEY AUTOINCREMENT,
             remoteid TEXT NOT NULL,
             remotename TEXT NOT NULL,
@@ -158,24 +571,197 @@ func createTables(db *sql.DB) error {
             status INTEGER NOT NULL,
             vendor INTEGER NOT NULL,
             lastpulled DATETIME NOT NULL,
-            UNIQUE(remotename, vendor))`
+            hash TEXT NOT NULL DEFAULT '',
+            revision TEXT NOT NULL DEFAULT '',
+            conflictpath TEXT NOT NULL DEFAULT '',
+            merge TEXT NOT NULL DEFAULT '',
+            account INTEGER NOT NULL DEFAULT 0,
+            interval INTEGER NOT NULL DEFAULT 0,
+            pullneeded INTEGER NOT NULL DEFAULT 0,
+            UNIQUE(remotename, account))`
+
+func createTables(db *sql.DB) error {
+  query := `CREATE TABLE IF NOT EXISTS files ` + filesSchema
 
   _, err := db.Exec(query)
   if err != nil {
     return err
   }
 
+  query = `CREATE TABLE IF NOT EXISTS settings (
+           key TEXT PRIMARY KEY,
+           value TEXT NOT NULL)`
+
+  _, err = db.Exec(query)
+  if err != nil {
+    return err
+  }
+
+  query = `CREATE TABLE IF NOT EXISTS accounts (
+           id INTEGER PRIMARY KEY AUTOINCREMENT,
+           vendor INTEGER NOT NULL,
+           name TEXT NOT NULL,
+           credential TEXT NOT NULL,
+           UNIQUE(vendor, name))`
+
+  _, err = db.Exec(query)
+  if err != nil {
+    return err
+  }
+
   return nil
 }
 
-func ensureDatabasePathExists() error {
-  u, err := user.Current()
+// migrateTables adds the columns that were introduced after the first
+// release to existing databases
+func migrateTables(db *sql.DB) error {
+  columns := []struct {
+    name string
+    definition string
+  }{
+    {"hash", "TEXT NOT NULL DEFAULT ''"},
+    {"revision", "TEXT NOT NULL DEFAULT ''"},
+    {"conflictpath", "TEXT NOT NULL DEFAULT ''"},
+    {"merge", "TEXT NOT NULL DEFAULT ''"},
+    {"account", "INTEGER NOT NULL DEFAULT 0"},
+    {"interval", "INTEGER NOT NULL DEFAULT 0"},
+    {"pullneeded", "INTEGER NOT NULL DEFAULT 0"},
+  }
+
+  for _, c := range columns {
+    exists, err := hasColumn(db, "files", c.name)
+    if err != nil {
+      return err
+    }
+    if exists {
+      continue
+    }
+
+    _, err = db.Exec("ALTER TABLE files ADD COLUMN " + c.name + " " + c.definition)
+    if err != nil {
+      return err
+    }
+  }
+
+  err := migrateUnique(db)
+  if err != nil {
+    return err
+  }
+
+  return migrateAccounts(db)
+}
+
+// migrateUnique rebuilds the files table of databases from before accounts,
+// where a remote name was unique per vendor instead of per account
+func migrateUnique(db *sql.DB) error {
+  var schema string
+  err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'files'").Scan(&schema)
+  if err != nil {
+    return err
+  }
+  if !strings.Contains(schema, "UNIQUE(remotename, vendor)") {
+    return nil
+  }
+
+  columns := "id, remoteid, remotename, localpath, status, vendor, lastpulled, hash, revision, conflictpath, merge, account, interval, pullneeded"
+
+  tx, err := db.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for _, query := range []string{
+    "CREATE TABLE files_new " + filesSchema,
+    "INSERT INTO files_new(" + columns + ") SELECT " + columns + " FROM files",
+    "DROP TABLE files",
+    "ALTER TABLE files_new RENAME TO files",
+  } {
+    _, err = tx.Exec(query)
+    if err != nil {
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+// migrateAccounts moves the files from before accounts to the default
+// account of their vendor
+func migrateAccounts(db *sql.DB) error {
+  rows, err := db.Query("SELECT DISTINCT vendor FROM files WHERE account = 0")
   if err != nil {
     return err
   }
 
-  p, _ := filepath.Abs(u.HomeDir +  configPath)
-  db = p + "/syncer.db"
+  vendors := make([]Vendor, 0)
+  for rows.Next() {
+    var vendor Vendor
+    err = rows.Scan(&vendor)
+    if err != nil {
+      rows.Close()
+      return err
+    }
+    vendors = append(vendors, vendor)
+  }
+  rows.Close()
+
+  for _, vendor := range vendors {
+    account, err := addAccount(db, vendor, DefaultAccount)
+    if err != nil {
+      return err
+    }
+
+    _, err = db.Exec("UPDATE files SET account = ? WHERE vendor = ? AND account = 0", account.Id, vendor)
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func hasColumn(db *sql.DB, table string, column string) (bool, error) {
+  rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+  if err != nil {
+    return false, err
+  }
+  defer rows.Close()
+
+  for rows.Next() {
+    var name string
+    err = rows.Scan(&name)
+    if err != nil {
+      return false, err
+    }
+    if name == column {
+      return true, nil
+    }
+  }
+
+  return false, rows.Err()
+}
+
+func ensureDatabasePathExists() error {
+  dir := configOverride
+  if dir == "" {
+    dir = os.Getenv("SYNCER_CONFIG")
+  }
+  if dir == "" {
+    u, err := user.Current()
+    if err != nil {
+      return err
+    }
+    dir = u.HomeDir + configPath
+  }
+
+  p, err := filepath.Abs(dir)
+  if err != nil {
+    return err
+  }
+  // Wait for locks instead of failing, the daemon writes from several goroutines
+  db = p + "/syncer.db?_busy_timeout=5000"
+  configDir = p
 
   err = os.MkdirAll(p, 0777)
   if err != nil {
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// initTestConfig keeps the database and the credentials of the test in a
+// temporary directory
+func initTestConfig(t *testing.T) {
+  SetConfigDir(t.TempDir())
+  t.Cleanup(func() { SetConfigDir("") })
+
+  err := Init()
+  if err != nil {
+    t.Fatal(err)
+  }
+}
+
+// addTestFiles watches the files with the given remote ids, in the default
+// account
+func addTestFiles(t *testing.T, remoteIds ...string) []File {
+  account, err := AddAccount(GoogleDrive, DefaultAccount)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  dir := t.TempDir()
+  for _, id := range remoteIds {
+    err = AddFile(File{RemoteId: id, RemoteName: id, LocalPath: filepath.Join(dir, id), Account: account})
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  files, err := GetFiles()
+  if err != nil {
+    t.Fatal(err)
+  }
+  return files
+}
+
+func TestPullNeeded(t *testing.T) {
+  initTestConfig(t)
+  files := addTestFiles(t, "a", "b")
+  account := files[0].Account.Id
+
+  pullNeeded := func() map[string]bool {
+    files, err := GetFiles()
+    if err != nil {
+      t.Fatal(err)
+    }
+    needed := make(map[string]bool)
+    for _, f := range files {
+      needed[f.RemoteId] = f.PullNeeded
+    }
+    return needed
+  }
+
+  err := markPullNeeded(account, []string{"a", "unknown"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if needed := pullNeeded(); !needed["a"] || needed["b"] {
+    t.Errorf("after marking a: %v", needed)
+  }
+
+  // A file from before the change doesn't clear it
+  err = UpdateFile(files[0])
+  if err != nil {
+    t.Fatal(err)
+  }
+  if needed := pullNeeded(); !needed["a"] {
+    t.Errorf("after an update: %v", needed)
+  }
+
+  err = setPullNeeded(&files[0], false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if needed := pullNeeded(); needed["a"] || needed["b"] {
+    t.Errorf("after clearing a: %v", needed)
+  }
+
+  err = markAllPullNeeded(account)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if needed := pullNeeded(); !needed["a"] || !needed["b"] {
+    t.Errorf("after marking all: %v", needed)
+  }
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrConflict = errors.New("unresolved conflict")
+
+type Resolution int
+const (
+  KeepLocal Resolution = iota
+  KeepRemote
+  KeepBoth
+)
+var resolutionString = map[Resolution]string{
+  KeepLocal: "Keep local",
+  KeepRemote: "Keep remote",
+  KeepBoth: "Keep both",
+}
+func (resolution Resolution) String() string {
+  return resolutionString[resolution]
+}
+
+// markConflict keeps the local file as it is and saves the remote version
+// next to it, so neither of both versions gets lost.
+// Files with a merge strategy that doesn't need a key are merged right away.
+func markConflict(repo Repository, file *File) error {
+  path := conflictPath(file.LocalPath, time.Now())
+
+  _, err := repo.Download(file, path)
+  if err != nil {
+    return err
+  }
+
+  file.Status = Conflict
+  file.ConflictPath = path
+  err = UpdateFile(*file)
+  if err != nil {
+    return err
+  }
+
+  if strategy, ok := mergeStrategies[file.Merge]; ok {
+    err = mergeConflict(repo, file, strategy, MergeOptions{})
+    if err == nil || errors.Is(err, ErrMergeConflict) {
+      return err
+    }
+  }
+
+  return fmt.Errorf("%w: %v changed locally and remotely, the remote version is saved as %v", ErrConflict, file.RemoteName, path)
+}
+
+func unresolvedConflict(file *File) error {
+  return fmt.Errorf("%w: %v, use \"syncer conflicts\" to resolve it", ErrConflict, file.RemoteName)
+}
+
+// conflictPath returns a free path for a conflict copy of the file,
+// i.e. "vault (conflict 2026-10-18 host).kdbx"
+func conflictPath(path string, t time.Time) string {
+  host, err := os.Hostname()
+  if err != nil || host == "" {
+    host = "unknown"
+  }
+
+  ext := filepath.Ext(path)
+  base := strings.TrimSuffix(path, ext)
+  name := fmt.Sprintf("conflict %v %v", t.Format("2006-01-02"), host)
+
+  p := fmt.Sprintf("%v (%v)%v", base, name, ext)
+  for i := 2; ; i++ {
+    _, err := os.Lstat(p)
+    if os.IsNotExist(err) {
+      return p
+    }
+    p = fmt.Sprintf("%v (%v %v)%v", base, name, i, ext)
+  }
+}
+
+// Resolve ends the conflict of the file.
+// KeepLocal overwrites the remote with the local file and removes the
+// conflict copy, KeepRemote overwrites the local file with the remote and
+// removes the conflict copy, KeepBoth overwrites the remote with the local
+// file but keeps the conflict copy as an untracked file.
+func Resolve(repo Repository, file *File, resolution Resolution) error {
+  if file.Status != Conflict {
+    return fmt.Errorf("%v has no conflict", file.RemoteName)
+  }
+
+  var remote Remote
+  var err error
+  switch resolution {
+    case KeepLocal, KeepBoth:
+      remote, err = repo.Upload(file)
+    case KeepRemote:
+      err = snapshot(*file)
+      if err == nil {
+        remote, err = repo.Download(file, file.LocalPath)
+      }
+    default:
+      err = fmt.Errorf("unknown resolution %v", resolution)
+  }
+  if err != nil {
+    return err
+  }
+
+  if resolution != KeepBoth && file.ConflictPath != "" {
+    err = os.Remove(file.ConflictPath)
+    if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+
+  file.Hash = remote.Hash
+  file.Revision = remote.Revision
+
+  return markSynced(file)
+}
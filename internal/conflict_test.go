@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// memoryRepository keeps the remote version of a file in memory
+type memoryRepository struct {
+  Repository
+  content []byte
+  revision int
+}
+
+func (repo *memoryRepository) remote() Remote {
+  sum := md5.Sum(repo.content)
+  return Remote{Hash: hex.EncodeToString(sum[:]), Revision: strconv.Itoa(repo.revision), Size: int64(len(repo.content))}
+}
+
+func (repo *memoryRepository) Stat(file *File) (Remote, error) {
+  return repo.remote(), nil
+}
+
+func (repo *memoryRepository) Download(file *File, path string) (Remote, error) {
+  return repo.remote(), writeFileAtomic(path, repo.content)
+}
+
+func (repo *memoryRepository) Upload(file *File) (Remote, error) {
+  data, err := os.ReadFile(file.LocalPath)
+  if err != nil {
+    return Remote{}, err
+  }
+  repo.content = data
+  repo.revision++
+  return repo.remote(), nil
+}
+
+func TestConflictPath(t *testing.T) {
+  host, err := os.Hostname()
+  if err != nil || host == "" {
+    host = "unknown"
+  }
+  dir := t.TempDir()
+  day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+
+  tests := []struct {
+    name string
+    path string
+    want string
+  }{
+    {"extension", "vault.kdbx", "vault (conflict 2026-10-18 " + host + ").kdbx"},
+    {"taken", "vault.kdbx", "vault (conflict 2026-10-18 " + host + " 2).kdbx"},
+    {"taken twice", "vault.kdbx", "vault (conflict 2026-10-18 " + host + " 3).kdbx"},
+    {"no extension", "notes", "notes (conflict 2026-10-18 " + host + ")"},
+    {"taken by a dangling symlink", "notes", "notes (conflict 2026-10-18 " + host + " 2)"},
+  }
+
+  for i, test := range tests {
+    path := conflictPath(filepath.Join(dir, test.path), day)
+    if path != filepath.Join(dir, test.want) {
+      t.Errorf("%v: path = %v, want %v", test.name, filepath.Base(path), test.want)
+    }
+
+    // The path is taken for the next test
+    if i == 3 {
+      err = os.Symlink(filepath.Join(dir, "missing"), path)
+    } else {
+      err = os.WriteFile(path, nil, 0600)
+    }
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+}
+
+func TestResolve(t *testing.T) {
+  tests := []struct {
+    resolution Resolution
+    local string
+    remote string
+    kept bool
+    snapshots int
+  }{
+    {KeepLocal, "local", "local", false, 0},
+    {KeepRemote, "remote", "remote", false, 1},
+    {KeepBoth, "local", "local", true, 0},
+  }
+
+  for _, test := range tests {
+    t.Run(test.resolution.String(), func(t *testing.T) {
+      initTestConfig(t)
+      file := addTestFiles(t, "notes.txt")[0]
+
+      repo := &memoryRepository{content: []byte("remote"), revision: 2}
+      file.ConflictPath = conflictPath(file.LocalPath, time.Now())
+      for path, content := range map[string]string{file.LocalPath: "local", file.ConflictPath: "remote"} {
+        err := os.WriteFile(path, []byte(content), 0600)
+        if err != nil {
+          t.Fatal(err)
+        }
+      }
+
+      err := Resolve(repo, &file, test.resolution)
+      if err == nil {
+        t.Fatal("resolved a file without a conflict")
+      }
+
+      file.Status = Conflict
+      err = UpdateFile(file)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      conflict := file.ConflictPath
+      err = Resolve(repo, &file, test.resolution)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      local, err := os.ReadFile(file.LocalPath)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if string(local) != test.local || string(repo.content) != test.remote {
+        t.Errorf("local = %q, remote = %q, want %q and %q", local, repo.content, test.local, test.remote)
+      }
+
+      if _, err = os.Stat(conflict); (err == nil) != test.kept {
+        t.Errorf("conflict copy kept = %v, want %v", err == nil, test.kept)
+      }
+
+      if file.Status != Synced || file.ConflictPath != "" || file.Hash != repo.remote().Hash || file.Revision != repo.remote().Revision {
+        t.Errorf("file = %v %v %v, want %v %v %v", file.Status, file.Hash, file.Revision, Synced, repo.remote().Hash, repo.remote().Revision)
+      }
+
+      snapshots, err := Snapshots(file)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if len(snapshots) != test.snapshots {
+        t.Errorf("%v snapshots, want %v", len(snapshots), test.snapshots)
+      }
+    })
+  }
+}
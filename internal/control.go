@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// controlService is the name the methods of the control socket are
+// registered with, i.e. "Syncerd.Status"
+const controlService = "Syncerd"
+
+var ErrDaemonNotRunning = errors.New("the syncer daemon is not running")
+
+// DaemonStatus is the state of a running daemon
+type DaemonStatus struct {
+  Pid int
+  Started time.Time
+  // LastSync is when the last sync of all files finished
+  LastSync time.Time
+  Syncing bool
+  Paused bool
+  Files int
+}
+
+// SyncRequest pulls or pushes the files with the ids
+type SyncRequest struct {
+  Ids []int
+  Push bool
+  Force bool
+}
+
+// SyncResponse is the outcome of syncing one file, the error is sent as
+// text because errors don't survive the socket
+type SyncResponse struct {
+  Id int
+  Outcome Outcome
+  Error string
+}
+
+// ControlHandler is what the daemon does for the requests on the control
+// socket
+type ControlHandler interface {
+  Status() DaemonStatus
+  // SyncNow starts syncing all files without waiting for the interval
+  SyncNow()
+  // Sync pulls or pushes the files and waits for the outcome
+  Sync(request SyncRequest) []SyncResponse
+  Pause()
+  Resume()
+  Reload() error
+  Shutdown()
+}
+
+// runtimeDir returns the directory of the control socket and the pid file,
+// in $XDG_RUNTIME_DIR or a directory of the user in /tmp without it
+func runtimeDir() string {
+  dir := os.Getenv("XDG_RUNTIME_DIR")
+  if dir == "" {
+    return filepath.Join(os.TempDir(), fmt.Sprintf("syncer-%v", os.Getuid()))
+  }
+  return filepath.Join(dir, "syncer")
+}
+
+// makeRuntimeDir creates the runtime directory when it doesn't exist yet
+func makeRuntimeDir() (string, error) {
+  dir := runtimeDir()
+
+  err := os.MkdirAll(dir, 0700)
+  if err != nil {
+    return "", err
+  }
+
+  err = checkRuntimeDir(dir)
+  if err != nil {
+    return "", err
+  }
+
+  return dir, nil
+}
+
+// checkRuntimeDir makes sure nobody else can reach the runtime directory.
+// Anyone can create the directory in /tmp before the user does, so it has
+// to be a real directory of the user with the mode 0700.
+func checkRuntimeDir(dir string) error {
+  info, err := os.Lstat(dir)
+  if err != nil {
+    return err
+  }
+  if !info.IsDir() {
+    return fmt.Errorf("%v is not a directory", dir)
+  }
+  if info.Mode().Perm() != 0700 {
+    return fmt.Errorf("%v has the mode %v, only its owner may use it", dir, info.Mode().Perm())
+  }
+
+  return checkOwner(dir, info)
+}
+
+// ControlSocket returns the path of the control socket of the daemon
+func ControlSocket() string {
+  return filepath.Join(runtimeDir(), "syncerd.sock")
+}
+
+// ListenControl creates the control socket, only the user can reach it.
+// It fails when another daemon is listening on it already.
+func ListenControl() (net.Listener, error) {
+  dir, err := makeRuntimeDir()
+  if err != nil {
+    return nil, err
+  }
+  path := filepath.Join(dir, "syncerd.sock")
+
+  conn, err := net.Dial("unix", path)
+  if err == nil {
+    conn.Close()
+    return nil, fmt.Errorf("another daemon is listening on %v", path)
+  }
+
+  // The socket of a daemon that didn't shut down cleanly is left behind
+  err = os.Remove(path)
+  if err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }
+
+  listener, err := net.Listen("unix", path)
+  if err != nil {
+    return nil, err
+  }
+
+  err = os.Chmod(path, 0600)
+  if err != nil {
+    listener.Close()
+    return nil, err
+  }
+
+  return listener, nil
+}
+
+// ServeControl answers the requests on the listener with the handler until
+// the listener is closed
+func ServeControl(listener net.Listener, handler ControlHandler) error {
+  server := rpc.NewServer()
+  err := server.RegisterName(controlService, &controlServer{handler: handler})
+  if err != nil {
+    return err
+  }
+
+  for {
+    conn, err := listener.Accept()
+    if errors.Is(err, net.ErrClosed) {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+  }
+}
+
+// controlServer adapts the handler to the method signatures of net/rpc
+type controlServer struct {
+  handler ControlHandler
+}
+
+// Empty is the argument and reply of the methods that don't need one
+type Empty struct{}
+
+func (s *controlServer) Status(_ Empty, reply *DaemonStatus) error {
+  *reply = s.handler.Status()
+  return nil
+}
+
+func (s *controlServer) SyncNow(_ Empty, _ *Empty) error {
+  s.handler.SyncNow()
+  return nil
+}
+
+func (s *controlServer) Sync(request SyncRequest, reply *[]SyncResponse) error {
+  *reply = s.handler.Sync(request)
+  return nil
+}
+
+func (s *controlServer) Pause(_ Empty, _ *Empty) error {
+  s.handler.Pause()
+  return nil
+}
+
+func (s *controlServer) Resume(_ Empty, _ *Empty) error {
+  s.handler.Resume()
+  return nil
+}
+
+func (s *controlServer) Reload(_ Empty, _ *Empty) error {
+  return s.handler.Reload()
+}
+
+func (s *controlServer) Shutdown(_ Empty, _ *Empty) error {
+  s.handler.Shutdown()
+  return nil
+}
+
+// ControlClient talks to the daemon over its control socket
+type ControlClient struct {
+  client *rpc.Client
+}
+
+// DialControl connects to the running daemon, ErrDaemonNotRunning is
+// returned when there is none
+func DialControl() (*ControlClient, error) {
+  err := checkRuntimeDir(runtimeDir())
+  if errors.Is(err, os.ErrNotExist) {
+    return nil, ErrDaemonNotRunning
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  conn, err := net.DialTimeout("unix", ControlSocket(), time.Second)
+  if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+    return nil, ErrDaemonNotRunning
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  return &ControlClient{client: jsonrpc.NewClient(conn)}, nil
+}
+
+func (c *ControlClient) Close() error {
+  return c.client.Close()
+}
+
+func (c *ControlClient) call(method string, args any, reply any) error {
+  return c.client.Call(controlService + "." + method, args, reply)
+}
+
+func (c *ControlClient) Status() (DaemonStatus, error) {
+  var status DaemonStatus
+  err := c.call("Status", Empty{}, &status)
+  return status, err
+}
+
+func (c *ControlClient) SyncNow() error {
+  return c.call("SyncNow", Empty{}, &Empty{})
+}
+
+func (c *ControlClient) Sync(request SyncRequest) ([]SyncResponse, error) {
+  var responses []SyncResponse
+  err := c.call("Sync", request, &responses)
+  return responses, err
+}
+
+func (c *ControlClient) Pause() error {
+  return c.call("Pause", Empty{}, &Empty{})
+}
+
+func (c *ControlClient) Resume() error {
+  return c.call("Resume", Empty{}, &Empty{})
+}
+
+func (c *ControlClient) Reload() error {
+  return c.call("Reload", Empty{}, &Empty{})
+}
+
+func (c *ControlClient) Shutdown() error {
+  err := c.call("Shutdown", Empty{}, &Empty{})
+  // The daemon may exit before its reply is sent
+  if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, rpc.ErrShutdown) {
+    return nil
+  }
+  return err
+}
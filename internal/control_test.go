@@ -0,0 +1,212 @@
+//go:build unix
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRuntimeDirFallback(t *testing.T) {
+  t.Setenv("XDG_RUNTIME_DIR", "")
+
+  tests := []struct {
+    name string
+    prepare func(dir string) error
+    err bool
+  }{
+    {name: "created", prepare: func(dir string) error { return nil }},
+    {name: "existing", prepare: func(dir string) error { return os.Mkdir(dir, 0700) }},
+    {
+      name: "readable by others",
+      prepare: func(dir string) error {
+        err := os.Mkdir(dir, 0700)
+        if err != nil {
+          return err
+        }
+        return os.Chmod(dir, 0755)
+      },
+      err: true,
+    },
+    {
+      name: "symlink",
+      prepare: func(dir string) error {
+        target := dir + "-target"
+        err := os.Mkdir(target, 0700)
+        if err != nil {
+          return err
+        }
+        return os.Symlink(target, dir)
+      },
+      err: true,
+    },
+    {name: "file", prepare: func(dir string) error { return os.WriteFile(dir, nil, 0700) }, err: true},
+  }
+
+  for _, test := range tests {
+    t.Setenv("TMPDIR", t.TempDir())
+    err := test.prepare(runtimeDir())
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    dir, err := makeRuntimeDir()
+    if test.err {
+      if err == nil {
+        t.Errorf("%v: %v was used", test.name, dir)
+      }
+      if _, err = ListenControl(); err == nil {
+        t.Errorf("%v: listening in %v", test.name, runtimeDir())
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%v: %v", test.name, err)
+      continue
+    }
+    if dir != filepath.Join(os.TempDir(), "syncer-" + strconv.Itoa(os.Getuid())) {
+      t.Errorf("%v: dir = %v", test.name, dir)
+    }
+  }
+}
+
+// testHandler records the requests of the control socket
+type testHandler struct {
+  mu sync.Mutex
+  calls []string
+  request SyncRequest
+}
+
+func (h *testHandler) called(name string) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.calls = append(h.calls, name)
+}
+
+func (h *testHandler) Status() DaemonStatus {
+  h.called("Status")
+  return DaemonStatus{Pid: 42, Started: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Paused: true, Files: 3}
+}
+
+func (h *testHandler) SyncNow() {
+  h.called("SyncNow")
+}
+
+func (h *testHandler) Sync(request SyncRequest) []SyncResponse {
+  h.called("Sync")
+  h.mu.Lock()
+  h.request = request
+  h.mu.Unlock()
+
+  responses := make([]SyncResponse, 0)
+  for _, id := range request.Ids {
+    responses = append(responses, SyncResponse{Id: id, Outcome: Pushed})
+  }
+  responses[len(responses) - 1] = SyncResponse{Id: request.Ids[len(request.Ids) - 1], Outcome: Failed, Error: "failed"}
+  return responses
+}
+
+func (h *testHandler) Pause() {
+  h.called("Pause")
+}
+
+func (h *testHandler) Resume() {
+  h.called("Resume")
+}
+
+func (h *testHandler) Reload() error {
+  h.called("Reload")
+  return errors.New("the database is locked")
+}
+
+func (h *testHandler) Shutdown() {
+  h.called("Shutdown")
+}
+
+func TestControl(t *testing.T) {
+  t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+  if _, err := DialControl(); !errors.Is(err, ErrDaemonNotRunning) {
+    t.Errorf("dial without a daemon = %v, want %v", err, ErrDaemonNotRunning)
+  }
+
+  listener, err := ListenControl()
+  if err != nil {
+    t.Fatal(err)
+  }
+  handler := &testHandler{}
+  served := make(chan error, 1)
+  go func() { served <- ServeControl(listener, handler) }()
+
+  if _, err = ListenControl(); err == nil {
+    t.Error("a second daemon listens on the socket")
+  }
+
+  info, err := os.Stat(ControlSocket())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if info.Mode().Perm() != 0600 {
+    t.Errorf("socket mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+  }
+
+  client, err := DialControl()
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer client.Close()
+
+  status, err := client.Status()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if status.Pid != 42 || !status.Paused || status.Files != 3 || !status.Started.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+    t.Errorf("status = %+v", status)
+  }
+
+  responses, err := client.Sync(SyncRequest{Ids: []int{1, 2}, Push: true, Force: true})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(responses) != 2 || responses[0] != (SyncResponse{Id: 1, Outcome: Pushed}) || responses[1] != (SyncResponse{Id: 2, Outcome: Failed, Error: "failed"}) {
+    t.Errorf("responses = %+v", responses)
+  }
+  handler.mu.Lock()
+  if !handler.request.Push || !handler.request.Force {
+    t.Errorf("request = %+v", handler.request)
+  }
+  handler.mu.Unlock()
+
+  for _, call := range []func() error{client.SyncNow, client.Pause, client.Resume, client.Shutdown} {
+    if err = call(); err != nil {
+      t.Error(err)
+    }
+  }
+
+  if err = client.Reload(); err == nil || err.Error() != "the database is locked" {
+    t.Errorf("reload = %v, want the error of the handler", err)
+  }
+
+  handler.mu.Lock()
+  defer handler.mu.Unlock()
+  want := []string{"Status", "Sync", "SyncNow", "Pause", "Resume", "Shutdown", "Reload"}
+  if len(handler.calls) != len(want) {
+    t.Fatalf("calls = %v, want %v", handler.calls, want)
+  }
+  for i := range want {
+    if handler.calls[i] != want[i] {
+      t.Errorf("calls = %v, want %v", handler.calls, want)
+      break
+    }
+  }
+
+  listener.Close()
+  if err = <-served; err != nil {
+    t.Errorf("serve = %v after closing the listener", err)
+  }
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkOwner fails when the runtime directory belongs to another user
+func checkOwner(dir string, info os.FileInfo) error {
+  stat, ok := info.Sys().(*syscall.Stat_t)
+  if !ok || int(stat.Uid) != os.Getuid() {
+    return fmt.Errorf("%v belongs to another user", dir)
+  }
+  return nil
+}
@@ -0,0 +1,391 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+  credentialStoreSetting = "credentials.store"
+  credentialCommandSetting = "credentials.command"
+  passphraseEnv = "SYNCER_PASSPHRASE"
+
+  encryptedMagic = "SYNCERC1"
+  encryptedSuffix = ".enc"
+
+  // The Argon2id parameters of new credentials
+  encryptedTime = 3
+  encryptedMemory = 64 * 1024
+  encryptedThreads = 4
+  // The header is only authenticated after the key is derived, so these
+  // limit what a damaged file can make the key derivation cost
+  encryptedMaxTime = 16
+  encryptedMaxMemory = 1024 * 1024
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or damaged credentials")
+
+// CredentialStore keeps secrets like OAuth tokens.
+// Load returns an error matching os.ErrNotExist for unknown names.
+type CredentialStore interface {
+  Load(name string) ([]byte, error)
+  Save(name string, data []byte) error
+  Delete(name string) error
+}
+
+// credentialNames returns all the credentials syncer keeps, they are moved
+// when another store is selected
+func credentialNames() ([]string, error) {
+  accounts, err := GetAccounts()
+  if err != nil {
+    return nil, err
+  }
+
+  names := []string{googleTokenName}
+  for _, a := range accounts {
+    if !slices.Contains(names, a.Credential) {
+      names = append(names, a.Credential)
+    }
+    if a.Vendor == GoogleDrive {
+      names = append(names, googleServiceAccount(a.Name))
+    }
+  }
+
+  return names, nil
+}
+
+var credentialStores = map[string]func() CredentialStore{
+  "file": func() CredentialStore {
+    return fileStore{dir: configDir}
+  },
+  "encrypted": func() CredentialStore {
+    return &encryptedStore{dir: configDir}
+  },
+  "pass": func() CredentialStore {
+    command, _ := GetSetting(credentialCommandSetting)
+    return commandStore{command: command}
+  },
+}
+
+// Passphrase asks for the passphrase of the encrypted credential store.
+// By default it is taken from SYNCER_PASSPHRASE, interactive programs
+// replace it with a prompt.
+var Passphrase = func() (string, error) {
+  passphrase := os.Getenv(passphraseEnv)
+  if passphrase == "" {
+    return "", fmt.Errorf("the credentials are encrypted, set %v to the passphrase", passphraseEnv)
+  }
+
+  return passphrase, nil
+}
+
+var passphraseCache struct {
+  mu sync.Mutex
+  value string
+}
+
+func CredentialStores() []string {
+  names := make([]string, 0)
+  for name := range credentialStores {
+    names = append(names, name)
+  }
+  slices.Sort(names)
+  return names
+}
+
+func IsCredentialStore(name string) bool {
+  _, ok := credentialStores[name]
+  return ok
+}
+
+// CredentialStoreName returns the name of the selected store
+func CredentialStoreName() (string, error) {
+  name, err := GetSetting(credentialStoreSetting)
+  if err != nil {
+    return "", err
+  }
+  if name == "" {
+    name = "file"
+  }
+
+  return name, nil
+}
+
+func credentialStore() (CredentialStore, error) {
+  name, err := CredentialStoreName()
+  if err != nil {
+    return nil, err
+  }
+
+  store, ok := credentialStores[name]
+  if !ok {
+    return nil, fmt.Errorf("unknown credential store %v", name)
+  }
+
+  return store(), nil
+}
+
+// SetCredentialStore moves all credentials into the store with the name and
+// keeps them there from now on
+func SetCredentialStore(name string) error {
+  newStore, ok := credentialStores[name]
+  if !ok {
+    return fmt.Errorf("unknown credential store %v, use one of: %v", name, strings.Join(CredentialStores(), ", "))
+  }
+
+  current, err := CredentialStoreName()
+  if err != nil {
+    return err
+  }
+  if current == name {
+    return nil
+  }
+
+  from, err := credentialStore()
+  if err != nil {
+    return err
+  }
+  to := newStore()
+
+  names, err := credentialNames()
+  if err != nil {
+    return err
+  }
+
+  moved := make([]string, 0)
+  for _, c := range names {
+    data, err := from.Load(c)
+    if errors.Is(err, os.ErrNotExist) {
+      continue
+    }
+    if err != nil {
+      return err
+    }
+
+    err = to.Save(c, data)
+    if err != nil {
+      return err
+    }
+    moved = append(moved, c)
+  }
+
+  err = SetSetting(credentialStoreSetting, name)
+  if err != nil {
+    return err
+  }
+
+  for _, c := range moved {
+    err = from.Delete(c)
+    if err != nil {
+      return fmt.Errorf("the credentials were moved, but %v could not be removed from the %v store: %w", c, current, err)
+    }
+  }
+
+  return nil
+}
+
+// fileStore keeps every credential as a plain file, readable by the user only
+type fileStore struct {
+  dir string
+}
+
+func (s fileStore) Load(name string) ([]byte, error) {
+  return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s fileStore) Save(name string, data []byte) error {
+  return writeFileAtomic(filepath.Join(s.dir, name), data)
+}
+
+func (s fileStore) Delete(name string) error {
+  err := os.Remove(filepath.Join(s.dir, name))
+  if os.IsNotExist(err) {
+    return nil
+  }
+
+  return err
+}
+
+// encryptedStore keeps every credential in a file encrypted with
+// XChaCha20-Poly1305, the key is derived from a passphrase with Argon2id.
+// The file starts with a header holding the KDF parameters, the salt and
+// the nonce, which is authenticated along with the content.
+type encryptedStore struct {
+  dir string
+}
+
+type encryptedHeader struct {
+  Time uint32
+  Memory uint32
+  Threads uint8
+  Salt [16]byte
+  Nonce [chacha20poly1305.NonceSizeX]byte
+}
+
+func (s *encryptedStore) Load(name string) ([]byte, error) {
+  data, err := os.ReadFile(filepath.Join(s.dir, name + encryptedSuffix))
+  if err != nil {
+    return nil, err
+  }
+
+  var header encryptedHeader
+  size := len(encryptedMagic) + binary.Size(header)
+  if len(data) < size || string(data[:len(encryptedMagic)]) != encryptedMagic {
+    return nil, fmt.Errorf("%v is not an encrypted credential", name)
+  }
+  binary.Read(bytes.NewReader(data[len(encryptedMagic):size]), binary.LittleEndian, &header)
+  if header.Time < 1 || header.Time > encryptedMaxTime || header.Threads < 1 || header.Memory < 8 * uint32(header.Threads) || header.Memory > encryptedMaxMemory {
+    return nil, fmt.Errorf("%v has invalid key derivation parameters, the file is damaged", name)
+  }
+
+  passphrase, err := passphrase()
+  if err != nil {
+    return nil, err
+  }
+
+  aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), header.Salt[:], header.Time, header.Memory, header.Threads, chacha20poly1305.KeySize))
+  if err != nil {
+    return nil, err
+  }
+
+  plain, err := aead.Open(nil, header.Nonce[:], data[size:], data[:size])
+  if err != nil {
+    forgetPassphrase()
+    return nil, ErrWrongPassphrase
+  }
+
+  return plain, nil
+}
+
+func (s *encryptedStore) Save(name string, data []byte) error {
+  passphrase, err := passphrase()
+  if err != nil {
+    return err
+  }
+
+  header := encryptedHeader{
+    Time: encryptedTime,
+    Memory: encryptedMemory,
+    Threads: encryptedThreads,
+  }
+  rand.Read(header.Salt[:])
+  rand.Read(header.Nonce[:])
+
+  aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), header.Salt[:], header.Time, header.Memory, header.Threads, chacha20poly1305.KeySize))
+  if err != nil {
+    return err
+  }
+
+  var buf bytes.Buffer
+  buf.WriteString(encryptedMagic)
+  binary.Write(&buf, binary.LittleEndian, header)
+
+  return writeFileAtomic(filepath.Join(s.dir, name + encryptedSuffix), aead.Seal(buf.Bytes(), header.Nonce[:], data, buf.Bytes()))
+}
+
+func (s *encryptedStore) Delete(name string) error {
+  err := os.Remove(filepath.Join(s.dir, name + encryptedSuffix))
+  if os.IsNotExist(err) {
+    return nil
+  }
+
+  return err
+}
+
+// passphrase asks for the passphrase once per process
+func passphrase() (string, error) {
+  passphraseCache.mu.Lock()
+  defer passphraseCache.mu.Unlock()
+
+  if passphraseCache.value != "" {
+    return passphraseCache.value, nil
+  }
+
+  value, err := Passphrase()
+  if err != nil {
+    return "", err
+  }
+  if value == "" {
+    return "", errors.New("the passphrase must not be empty")
+  }
+  passphraseCache.value = value
+
+  return value, nil
+}
+
+func forgetPassphrase() {
+  passphraseCache.mu.Lock()
+  defer passphraseCache.mu.Unlock()
+
+  passphraseCache.value = ""
+}
+
+// commandStore keeps the credentials in an external password manager with
+// the interface of pass, like pass itself or gopass
+type commandStore struct {
+  command string
+}
+
+func (s commandStore) entry(name string) string {
+  return "syncer/" + name
+}
+
+func (s commandStore) Load(name string) ([]byte, error) {
+  var stderr bytes.Buffer
+  cmd := exec.Command(s.command, "show", s.entry(name))
+  cmd.Stderr = &stderr
+
+  out, err := cmd.Output()
+  if strings.Contains(stderr.String(), "not in the password store") {
+    return nil, fmt.Errorf("%v: %w", s.entry(name), os.ErrNotExist)
+  }
+  if err != nil {
+    return nil, fmt.Errorf("%v show %v: %v %v", s.command, s.entry(name), err, strings.TrimSpace(stderr.String()))
+  }
+
+  return out, nil
+}
+
+func (s commandStore) Save(name string, data []byte) error {
+  var stderr bytes.Buffer
+  cmd := exec.Command(s.command, "insert", "--multiline", "--force", s.entry(name))
+  cmd.Stdin = bytes.NewReader(data)
+  cmd.Stderr = &stderr
+
+  err := cmd.Run()
+  if err != nil {
+    return fmt.Errorf("%v insert %v: %v %v", s.command, s.entry(name), err, strings.TrimSpace(stderr.String()))
+  }
+
+  return nil
+}
+
+func (s commandStore) Delete(name string) error {
+  _, err := s.Load(name)
+  if errors.Is(err, os.ErrNotExist) {
+    return nil
+  }
+
+  var stderr bytes.Buffer
+  cmd := exec.Command(s.command, "rm", "--force", s.entry(name))
+  cmd.Stderr = &stderr
+
+  err = cmd.Run()
+  if err != nil {
+    return fmt.Errorf("%v rm %v: %v %v", s.command, s.entry(name), err, strings.TrimSpace(stderr.String()))
+  }
+
+  return nil
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+  t.Setenv(passphraseEnv, "passphrase")
+  forgetPassphrase()
+  defer forgetPassphrase()
+
+  store := &encryptedStore{dir: t.TempDir()}
+  err := store.Save("token", []byte("secret"))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  data, err := store.Load("token")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(data) != "secret" {
+    t.Errorf("loaded %q, want %q", data, "secret")
+  }
+
+  if _, err = store.Load("other"); !errors.Is(err, os.ErrNotExist) {
+    t.Errorf("loading an unknown credential = %v, want %v", err, os.ErrNotExist)
+  }
+}
+
+func TestEncryptedStoreDamagedHeader(t *testing.T) {
+  t.Setenv(passphraseEnv, "passphrase")
+  forgetPassphrase()
+  defer forgetPassphrase()
+
+  store := &encryptedStore{dir: t.TempDir()}
+  err := store.Save("token", []byte("secret"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  path := filepath.Join(store.dir, "token" + encryptedSuffix)
+  original, err := os.ReadFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // The header is Time, Memory and Threads after the magic
+  tests := []struct {
+    name string
+    damage func(data []byte)
+  }{
+    {"huge memory", func(data []byte) { binary.LittleEndian.PutUint32(data[len(encryptedMagic) + 4:], 0xffffffff) }},
+    {"huge time", func(data []byte) { binary.LittleEndian.PutUint32(data[len(encryptedMagic):], 0xffffffff) }},
+    {"no threads", func(data []byte) { data[len(encryptedMagic) + 8] = 0 }},
+  }
+
+  for _, test := range tests {
+    data := append([]byte{}, original...)
+    test.damage(data)
+    err = os.WriteFile(path, data, 0600)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if _, err = store.Load("token"); err == nil {
+      t.Errorf("%v: loaded a damaged credential", test.name)
+    }
+  }
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces the file at path with data, the file is either
+// completely replaced or left untouched
+func writeFileAtomic(path string, data []byte) error {
+  return replaceFile(path, bytes.NewReader(data), Remote{})
+}
+
+// replaceFile streams r into a temporary file next to path and renames it
+// over path once it is complete and on disk.
+// When the size, hash or modification time of expected are set, the content
+// is verified against them and the modification time is applied.
+// The mode of an existing file is kept, and when path is a symlink, i.e. of
+// a dotfile manager, the file it points to is replaced instead of the link.
+// On any error the file at path is left untouched.
+func replaceFile(path string, r io.Reader, expected Remote) error {
+  path, err := resolveSymlinks(path)
+  if err != nil {
+    return err
+  }
+
+  dir := filepath.Dir(path)
+  err = os.MkdirAll(dir, 0777)
+  if err != nil {
+    return err
+  }
+
+  tmp, err := os.CreateTemp(dir, "." + filepath.Base(path) + ".*.tmp")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+  defer tmp.Close()
+
+  h := md5.New()
+  written, err := io.Copy(io.MultiWriter(tmp, h), r)
+  if err != nil {
+    return err
+  }
+
+  if expected.Size > 0 && written != expected.Size {
+    return fmt.Errorf("incomplete download of %v: got %v of %v bytes", path, written, expected.Size)
+  }
+  if hash := hex.EncodeToString(h.Sum(nil)); expected.Hash != "" && hash != expected.Hash {
+    return fmt.Errorf("corrupted download of %v: checksum %v does not match %v", path, hash, expected.Hash)
+  }
+
+  mode := os.FileMode(0600)
+  if info, err := os.Stat(path); err == nil {
+    mode = info.Mode().Perm()
+  }
+
+  err = tmp.Chmod(mode)
+  if err != nil {
+    return err
+  }
+
+  err = tmp.Sync()
+  if err != nil {
+    return err
+  }
+
+  err = tmp.Close()
+  if err != nil {
+    return err
+  }
+
+  if !expected.Modified.IsZero() {
+    err = os.Chtimes(tmp.Name(), expected.Modified, expected.Modified)
+    if err != nil {
+      return err
+    }
+  }
+
+  err = os.Rename(tmp.Name(), path)
+  if err != nil {
+    return err
+  }
+
+  return syncDir(dir)
+}
+
+// resolveSymlinks returns the file path points to, a link to a file that
+// doesn't exist yet is followed as well
+func resolveSymlinks(path string) (string, error) {
+  resolved, err := filepath.EvalSymlinks(path)
+  if err == nil {
+    return resolved, nil
+  }
+  if !os.IsNotExist(err) {
+    return "", err
+  }
+
+  target, err := os.Readlink(path)
+  if err != nil {
+    return path, nil
+  }
+  if !filepath.IsAbs(target) {
+    target = filepath.Join(filepath.Dir(path), target)
+  }
+
+  return resolveSymlinks(target)
+}
+
+// syncDir flushes the directory entry of a rename to disk
+func syncDir(dir string) error {
+  d, err := os.Open(dir)
+  if err != nil {
+    return err
+  }
+  defer d.Close()
+
+  return d.Sync()
+}
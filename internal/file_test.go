@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+  dir := t.TempDir()
+  target := filepath.Join(dir, "dotfiles", "config")
+  link := filepath.Join(dir, "config")
+
+  err := os.MkdirAll(filepath.Dir(target), 0700)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = os.WriteFile(target, []byte("old"), 0640)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = os.Symlink(filepath.Join("dotfiles", "config"), link)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  err = writeFileAtomic(link, []byte("new"))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  info, err := os.Lstat(link)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if info.Mode() & os.ModeSymlink == 0 {
+    t.Error("the symlink was replaced by a file")
+  }
+
+  data, err := os.ReadFile(target)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(data) != "new" {
+    t.Errorf("target = %q, want %q", data, "new")
+  }
+
+  info, err = os.Stat(target)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if info.Mode().Perm() != 0640 {
+    t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+  }
+}
+
+func TestWriteFileAtomicDanglingSymlink(t *testing.T) {
+  dir := t.TempDir()
+  target := filepath.Join(dir, "target")
+  link := filepath.Join(dir, "link")
+
+  err := os.Symlink(target, link)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  err = writeFileAtomic(link, []byte("new"))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  data, err := os.ReadFile(target)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(data) != "new" {
+    t.Errorf("target = %q, want %q", data, "new")
+  }
+  if info, err := os.Lstat(link); err != nil || info.Mode() & os.ModeSymlink == 0 {
+    t.Error("the symlink was replaced by a file")
+  }
+}
+
+func TestReplaceFileVerify(t *testing.T) {
+  content := []byte("remote content")
+  sum := md5.Sum(content)
+  hash := hex.EncodeToString(sum[:])
+
+  tests := []struct {
+    name string
+    expected Remote
+    err bool
+  }{
+    {name: "verified", expected: Remote{Size: int64(len(content)), Hash: hash}},
+    {name: "nothing to verify", expected: Remote{}},
+    {name: "size mismatch", expected: Remote{Size: int64(len(content)) + 1, Hash: hash}, err: true},
+    {name: "truncated", expected: Remote{Size: int64(len(content)) * 2}, err: true},
+    {name: "md5 mismatch", expected: Remote{Size: int64(len(content)), Hash: "0123456789abcdef0123456789abcdef"}, err: true},
+  }
+
+  for _, test := range tests {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "file")
+    err := os.WriteFile(path, []byte("local content"), 0640)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    err = replaceFile(path, bytes.NewReader(content), test.expected)
+    if test.err && err == nil {
+      t.Errorf("%v: no error", test.name)
+    }
+    if !test.err && err != nil {
+      t.Errorf("%v: %v", test.name, err)
+    }
+
+    want := content
+    if test.err {
+      want = []byte("local content")
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !bytes.Equal(data, want) {
+      t.Errorf("%v: file = %q, want %q", test.name, data, want)
+    }
+
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if len(entries) != 1 {
+      names := make([]string, 0)
+      for _, e := range entries {
+        names = append(names, e.Name())
+      }
+      t.Errorf("%v: left %v in the directory", test.name, names)
+    }
+  }
+}
@@ -1,24 +1,20 @@
 package internal
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"io"
-	"net"
+	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
@@ -26,359 +22,739 @@ const (
   SECRET = "eyJpbnN0YWxsZWQiOnsiY2xpZW50X2lkIjoiOTM0OTc2MzcyNTM5LXVrc3ZocnFtcW1mN2E4YXFyMHZqOXQ2cjhvaWp0bWR0LmFwcHMuZ29vZ2xldXNlcmNvbnRlbnQuY29tIiwicHJvamVjdF9pZCI6Im15LXByb2plY3QtMTUwNjA4OTg2Mjk3MyIsImF1dGhfdXJpIjoiaHR0cHM6Ly9hY2NvdW50cy5nb29nbGUuY29tL28vb2F1dGgyL2F1dGgiLCJ0b2tlbl91cmkiOiJodHRwczovL29hdXRoMi5nb29nbGVhcGlzLmNvbS90b2tlbiIsImF1dGhfcHJvdmlkZXJfeDUwOV9jZXJ0X3VybCI6Imh0dHBzOi8vd3d3Lmdvb2dsZWFwaXMuY29tL29hdXRoMi92MS9jZXJ0cyIsImNsaWVudF9zZWNyZXQiOiJHT0NTUFgtcjBCQ2NxSXdTWElFeXFRNU12TkUxeUlMY1lGNSIsInJlZGlyZWN0X3VyaXMiOlsiaHR0cDovL2xvY2FsaG9zdDozMzMzIl19fQo"
 )
 
+const (
+  googlePageToken = "pagetoken"
+  googleSubject = "subject"
+  googleTokenName = "google_token.json"
+  googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+  googleUnwatchTimeout = time.Second * 10
+)
+
+// Google is the repository of a Google Drive account.
+// The Drive service is created on first use and shared by all calls, which
+// may come from several goroutines.
 type Google struct {
-  TokenPath string
+  // Account is the name of the account, it separates the state of
+  // several accounts
+  Account string
+  // TokenName is the name of the token in the credential store
+  TokenName string
+  // SecretPath holds the OAuth client the user set for the account, the
+  // built-in client is used when there is none
+  SecretPath string
+  // ServiceAccountName is the name of the key of a service account in the
+  // credential store, it is used instead of the token when it exists
+  ServiceAccountName string
+  // AccountId is the id of the account in the database, the remote changes
+  // are recorded on its files
+  AccountId int
+
+  mu sync.Mutex
+  srv *drive.Service
+  ctx context.Context
 }
 
-func (g Google) Pull(file *File) error {
-  // Set status to error, so only a valid pull gets the status 'Synced'
-  file.Status = Error
-  err := UpdateFile(*file)
-  if err != nil {
-    return err
+func newGoogle(account Account) *Google {
+  return &Google{
+    Account: account.Name,
+    TokenName: account.Credential,
+    SecretPath: filepath.Join(configDir, "google_client_secret" + accountSuffix(account.Name) + ".json"),
+    ServiceAccountName: googleServiceAccount(account.Name),
+    AccountId: account.Id,
   }
+}
 
-  client, err := getClient(&g)
-  if err != nil {
-    return err
+// googleCredential is the name of the token of the account, the default
+// account keeps the name from before there were accounts
+func googleCredential(name string) string {
+  return "google_token" + accountSuffix(name) + ".json"
+}
+
+// googleServiceAccount is the name of the service account key of the
+// account
+func googleServiceAccount(name string) string {
+  return "google_service_account" + accountSuffix(name) + ".json"
+}
+
+// settingKey returns the key of a setting that belongs to the account
+func (g *Google) settingKey(key string) string {
+  if g.Account == "" || g.Account == DefaultAccount {
+    return "google." + key
   }
+  return "google." + g.Account + "." + key
+}
 
-  srv, err := drive.NewService(context.TODO(), option.WithHTTPClient(client))
+// SetContext sets the context all further requests are made with,
+// cancelling it aborts the running requests
+func (g *Google) SetContext(ctx context.Context) {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+
+  g.ctx = ctx
+}
+
+func (g *Google) context() context.Context {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+
+  if g.ctx == nil {
+    return context.Background()
+  }
+  return g.ctx
+}
+
+// service returns the shared Drive service, the token is only read once
+func (g *Google) service() (*drive.Service, error) {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+
+  if g.srv != nil {
+    return g.srv, nil
+  }
+
+  client, err := getClient(g)
   if err != nil {
-    return err
+    return nil, err
   }
 
-  f, err := srv.Files.Get(file.RemoteId).Do()
+  srv, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
   if err != nil {
-    return err
+    return nil, err
   }
+  g.srv = srv
+
+  return srv, nil
+}
 
-  rt, err := time.Parse(time.RFC3339, f.ModifiedDate)
+func (g *Google) Stat(file *File) (Remote, error) {
+  srv, err := g.service()
   if err != nil {
-    return err
+    return Remote{}, err
   }
-  lt, err := time.Parse(time.RFC3339, file.LastPulled)
+
+  f, err := srv.Files.Get(file.RemoteId).Context(g.context()).Do()
   if err != nil {
-    return err
+    return Remote{}, err
   }
 
-  modified := rt.Sub(lt) > 0 // Checking if remote is modified
-  if !modified {
-    file.Status = Synced
-    err = UpdateFile(*file)
-    if err != nil {
-      return err
-    }
-    return nil
+  return remoteFromDrive(f), nil
+}
+
+func remoteFromDrive(f *drive.File) Remote {
+  modified, _ := time.Parse(time.RFC3339, f.ModifiedDate)
+
+  return Remote{
+    Hash: f.Md5Checksum,
+    Revision: f.HeadRevisionId,
+    Size: f.FileSize,
+    Modified: modified,
   }
+}
 
-  res, err := srv.Files.Get(f.Id).Download()
-  if err != nil {
-    return err
+func (g *Google) Pull(file *File) error {
+  if file.Status == Paused {
+    return fmt.Errorf("%v: %w", file.RemoteName, ErrPaused)
+  }
+  if file.Status == Conflict {
+    return unresolvedConflict(file)
   }
-  defer res.Body.Close()
 
-  err = saveFile(res, file.LocalPath)
+  // Set status to error, so only a valid pull gets the status 'Synced'
+  previous := file.Status
+  file.Status = Error
+  err := UpdateFile(*file)
   if err != nil {
     return err
   }
-  
-  file.Status = Synced
-  err = UpdateFile(*file)
+
+  plan, err := PlanFile(g, file)
   if err != nil {
-    return err
+    return pullFailed(file, previous, err)
   }
 
-  return nil
-}
+  switch plan.Change {
+    case BothChanged:
+      return markConflict(g, file)
+    case RemoteChanged:
+      err = snapshot(*file)
+      if err != nil {
+        return pullFailed(file, previous, err)
+      }
 
-func (g Google) PullAll(files []File) error {
-  errs := make([]string, 0)
+      remote, err := g.Download(file, file.LocalPath)
+      if err != nil {
+        return pullFailed(file, previous, err)
+      }
 
-  for _, lf := range files {
-    err := g.Pull(&lf)
-    if err != nil {
-      errs = append(errs, err.Error())
-    }
+      file.Hash = remote.Hash
+      file.Revision = remote.Revision
+    case LocalChanged:
+      // The file stays unsynced until the local changes are pushed
+      return nil
+    case Unchanged:
+      file.Hash = plan.Local
+      file.Revision = plan.Remote.Revision
   }
 
-  // Hanlde Errors
-  if len(errs) > 0 {
-    return errors.New(strings.Join(errs, "\n"))
+  return markSynced(file)
+}
+
+// Download saves the current remote version of the file at path
+func (g *Google) Download(file *File, path string) (Remote, error) {
+  srv, err := g.service()
+  if err != nil {
+    return Remote{}, err
   }
 
-  return nil
-}
+  rf, err := srv.Files.Get(file.RemoteId).Context(g.context()).Do()
+  if err != nil {
+    return Remote{}, err
+  }
 
-func saveFile(response *http.Response, path string) error {
-  // TODO: bug with writing to much data to the file
-  dir := filepath.Dir(path)
-  err := os.MkdirAll(dir, 0777)
+  res, err := srv.Files.Get(rf.Id).Context(g.context()).Download()
   if err != nil {
-    return err
+    return Remote{}, err
+  }
+  defer res.Body.Close()
+
+  remote := remoteFromDrive(rf)
+  err = saveFile(res, path, remote)
+  if err != nil {
+    return Remote{}, err
   }
 
-  destFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+  return remote, nil
+}
+
+// saveFile writes the downloaded content to path atomically, after checking
+// it against the size and checksum of the remote file
+func saveFile(response *http.Response, path string, remote Remote) error {
+  return replaceFile(path, response.Body, remote)
+}
+
+func (g *Google) Push(file *File) error {
+  if file.Status == Paused {
+    return fmt.Errorf("%v: %w", file.RemoteName, ErrPaused)
+  }
+  if file.Status == Conflict {
+    return unresolvedConflict(file)
+  }
 
+  previous := file.Status
+  file.Status = Error
+  err := UpdateFile(*file)
   if err != nil {
     return err
   }
 
-  buffer := make([]byte, 32 * 1024)
-  pos := 0
-  for {
-    read, err := response.Body.Read(buffer)
+  plan, err := PlanFile(g, file)
+  if err != nil {
+    return syncFailed(file, previous, err)
+  }
 
-    if read > 0 {
-      written, err := destFile.WriteAt(buffer, int64(pos))
+  switch plan.Change {
+    case BothChanged:
+      return markConflict(g, file)
+    case LocalChanged:
+      remote, err := g.Upload(file)
       if err != nil {
-        break
+        return syncFailed(file, previous, err)
       }
-      pos += written
-    }
 
-    if err != nil {
-      if err == io.EOF {
-        break
+      file.Hash = remote.Hash
+      file.Revision = remote.Revision
+    case RemoteChanged:
+      // The file is left as it was, the remote change has to be pulled
+      file.Status = previous
+      err = UpdateFile(*file)
+      if err != nil {
+        return err
       }
-      break
-    }
+      err = setPullNeeded(file, true)
+      if err != nil {
+        return err
+      }
+      return fmt.Errorf("%v: %w", file.RemoteName, ErrRemoteChanged)
+    case Unchanged:
+      file.Hash = plan.Local
+      file.Revision = plan.Remote.Revision
   }
 
-  return nil
+  return markSynced(file)
 }
 
-func (g Google) Push(file *File) error {
-  file.Status = Error
-  err := UpdateFile(*file)
+// Upload replaces the remote content with the local file
+func (g *Google) Upload(file *File) (Remote, error) {
+  srv, err := g.service()
   if err != nil {
-    return err
+    return Remote{}, err
   }
 
-  client, err := getClient(&g)
+  lf, err := os.Open(file.LocalPath)
   if err != nil {
-    return err
+    return Remote{}, err
   }
+  defer lf.Close()
 
-  srv, err := drive.NewService(context.TODO(), option.WithHTTPClient(client))
+  rf, err := srv.Files.Update(file.RemoteId, &drive.File{}).Media(lf).Context(g.context()).Do()
   if err != nil {
-    return err
+    return Remote{}, err
   }
 
-  // Update file
-  lf, err := os.Open(file.LocalPath)
+  return remoteFromDrive(rf), nil
+}
+
+// Changes returns the ids of the remote files that changed since the last
+// call, based on the Drive changes feed.
+// The position in the feed is kept in the database. Without a valid position
+// the current one is stored and ErrChangesUnknown is returned.
+// The changed files are marked as needing a pull before the position moves,
+// so no change is lost when they aren't pulled right away.
+func (g *Google) Changes() ([]string, error) {
+  token, err := GetSetting(g.settingKey(googlePageToken))
   if err != nil {
-    return err
+    return nil, err
   }
-  defer lf.Close()
 
-  rf, err := srv.Files.Get(file.RemoteId).Do()
+  srv, err := g.service()
   if err != nil {
-    return err
+    return nil, err
+  }
+
+  if token == "" {
+    start, err := srv.Changes.GetStartPageToken().Context(g.context()).Do()
+    if err != nil {
+      return nil, err
+    }
+
+    err = markAllPullNeeded(g.AccountId)
+    if err != nil {
+      return nil, err
+    }
+
+    err = SetSetting(g.settingKey(googlePageToken), start.StartPageToken)
+    if err != nil {
+      return nil, err
+    }
+
+    return nil, ErrChangesUnknown
+  }
+
+  ids := make([]string, 0)
+  for {
+    r, err := srv.Changes.List().
+      PageToken(token).
+      IncludeDeleted(true).
+      Fields("items/fileId", "nextPageToken", "newStartPageToken").
+      Context(g.context()).
+      Do()
+    var apiErr *googleapi.Error
+    if errors.As(err, &apiErr) && (apiErr.Code == http.StatusBadRequest || apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone) {
+      // The stored position expired, start over
+      err = markAllPullNeeded(g.AccountId)
+      if err != nil {
+        return nil, err
+      }
+      err = SetSetting(g.settingKey(googlePageToken), "")
+      if err != nil {
+        return nil, err
+      }
+      return nil, ErrChangesUnknown
+    }
+    if err != nil {
+      return nil, err
+    }
+
+    for _, c := range r.Items {
+      ids = append(ids, c.FileId)
+    }
+
+    if r.NewStartPageToken != "" {
+      token = r.NewStartPageToken
+      break
+    }
+    token = r.NextPageToken
   }
 
-  _, err = srv.Files.Update(rf.Id, rf).Media(lf).Do()
+  err = markPullNeeded(g.AccountId, ids)
   if err != nil {
-    return err
+    return nil, err
   }
 
-  file.Status = Synced
-  err = UpdateFile(*file)
+  err = SetSetting(g.settingKey(googlePageToken), token)
   if err != nil {
-    return err
+    return nil, err
   }
 
-  return nil
+  return ids, nil
 }
 
-func (g Google) PushAll(files []File) error {
-  errs := make([]string, 0)
+// Watch registers the channel, which sends a notification to address
+// whenever something changes in the drive. The channel is returned with
+// its resource and expiration.
+func (g *Google) Watch(address string, channel Channel) (Channel, error) {
+  token, err := GetSetting(g.settingKey(googlePageToken))
+  if err != nil {
+    return Channel{}, err
+  }
 
-  for _, lf := range files {
-    err := g.Push(&lf)
+  srv, err := g.service()
+  if err != nil {
+    return Channel{}, err
+  }
+
+  if token == "" {
+    start, err := srv.Changes.GetStartPageToken().Context(g.context()).Do()
     if err != nil {
-      errs = append(errs, err.Error())
+      return Channel{}, err
     }
+    token = start.StartPageToken
   }
 
-  // Hanlde Errors
-  if len(errs) > 0 {
-    return errors.New(strings.Join(errs, "\n"))
+  c, err := srv.Changes.Watch(&drive.Channel{
+    Id: channel.Id,
+    Type: "web_hook",
+    Address: address,
+    Token: channel.Token,
+  }).PageToken(token).Context(g.context()).Do()
+  if err != nil {
+    return Channel{}, err
   }
 
-  return nil
+  channel.ResourceId = c.ResourceId
+  channel.Expiration = time.UnixMilli(c.Expiration)
+
+  return channel, nil
 }
 
-func (g Google) List() ([]string, error) {
-  client, err := getClient(&g)
+// Unwatch stops the notifications of the channel. Channels are stopped when
+// shutting down, so the request isn't canceled with the others.
+func (g *Google) Unwatch(channel Channel) error {
+  srv, err := g.service()
   if err != nil {
-    return nil, err
+    return err
   }
 
-  srv, err := drive.NewService(context.TODO(), option.WithHTTPClient(client))
+  ctx, cancel := context.WithTimeout(context.WithoutCancel(g.context()), googleUnwatchTimeout)
+  defer cancel()
+
+  return srv.Channels.Stop(&drive.Channel{
+    Id: channel.Id,
+    ResourceId: channel.ResourceId,
+  }).Context(ctx).Do()
+}
+
+func (g *Google) List() ([]RemoteFile, error) {
+  srv, err := g.service()
   if err != nil {
     return nil, err
   }
 
   // TODO: fields don't work
-  r, err := srv.Files.List().Do()
+  r, err := srv.Files.List().Context(g.context()).Do()
   if err != nil {
     return nil, err
   }
 
-  remoteFiles := make([]string, 0)
+  remoteFiles := make([]RemoteFile, 0)
   for _, f := range r.Items {
     if f.OriginalFilename == "" {
       continue
     }
-    remoteFiles = append(remoteFiles, " - " + f.OriginalFilename)
+    remoteFiles = append(remoteFiles, RemoteFile{Id: f.Id, Name: f.OriginalFilename})
   }
 
   return remoteFiles, nil
 }
 
 func getClient(g *Google) (*http.Client, error) {
-  data, err := base64.RawStdEncoding.DecodeString(SECRET)
-  if err != nil {
+  key, err := g.readServiceAccount()
+  if err == nil {
+    subject, err := GetSetting(g.settingKey(googleSubject))
+    if err != nil {
+      return nil, err
+    }
+    return serviceAccountClient(key, subject)
+  }
+  if !errors.Is(err, os.ErrNotExist) {
     return nil, err
   }
 
-  c, err := google.ConfigFromJSON(data, drive.DriveScope)
+  c, err := g.oauthConfig()
   if err != nil {
     return nil, err
   }
 
-  file, err := os.Open(g.TokenPath)
+  tok, err := readToken(g.TokenName)
   if err != nil {
     return nil, err
   }
-  defer file.Close()
-  tok := &oauth2.Token{}
-  _ = json.NewDecoder(file).Decode(tok)
 
-  return c.Client(context.Background(), tok), nil
+  return oauth2.NewClient(context.Background(), newSavingTokenSource(g.TokenName, c, tok)), nil
 }
 
-func (g Google) Authenticate() error {
-  _, err := os.Open(g.TokenPath)
-  if err == nil {
-    return nil
+// readServiceAccount loads the service account key from the credential
+// store. Keys used to be plain files in the config directory whatever the
+// store, such a key is moved into the store.
+func (g *Google) readServiceAccount() ([]byte, error) {
+  store, err := credentialStore()
+  if err != nil {
+    return nil, err
+  }
+
+  key, err := store.Load(g.ServiceAccountName)
+  if !errors.Is(err, os.ErrNotExist) {
+    return key, err
   }
 
-  // TODO: find a better way to handle the secret
-  secret, err := os.ReadFile("secret.json")
+  path := filepath.Join(configDir, g.ServiceAccountName)
+  key, err = os.ReadFile(path)
   if err != nil {
-    return err
+    return nil, err
+  }
+
+  err = store.Save(g.ServiceAccountName, key)
+  if err != nil {
+    return nil, err
   }
+  os.Remove(path)
+
+  return key, nil
+}
 
-  config, err := google.ConfigFromJSON(secret, drive.DriveScope)
+// serviceAccountClient authenticates as the service account, or as the user
+// it impersonates through domain-wide delegation
+func serviceAccountClient(key []byte, subject string) (*http.Client, error) {
+  config, err := google.JWTConfigFromJSON(key, drive.DriveScope)
+  if err != nil {
+    return nil, fmt.Errorf("%w: invalid service account key: %v", ErrNeedsReauth, err)
+  }
+  config.Subject = subject
+
+  src := rejectedTokenSource{config.TokenSource(context.Background())}
+  return oauth2.NewClient(context.Background(), src), nil
+}
+
+func (g *Google) Authenticate(options AuthOptions) error {
+  if options.ServiceAccount != "" {
+    return g.setServiceAccount(options.ServiceAccount, options.Subject)
+  }
+
+  // Offline the token can't be checked, it's kept until it is rejected
+  state := g.AuthState()
+  if state == Authenticated || state == AuthUnknown {
+    return nil
+  }
+
+  config, err := g.oauthConfig()
   if err != nil {
     return err
   }
 
-  token, err := getTokenFromWeb(config)
+  var token *oauth2.Token
+  if options.Device {
+    token, err = getTokenFromDevice(g.context(), config, os.Stdout)
+  } else {
+    token, err = getTokenFromWeb(g.context(), config)
+  }
   if err != nil {
     return err
   }
 
-  err = saveToken(g.TokenPath, token)
+  err = saveToken(g.TokenName, token)
   if err != nil {
     return err
   }
 
+  // Pick up the new token on the next request
+  g.mu.Lock()
+  g.srv = nil
+  g.mu.Unlock()
+
   return nil
 }
 
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-  authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-  exec.Command("xdg-open", authURL).Start()
-
-  code, err := getAuthorizationcodeFromRedirect()
+// oauthConfig returns the OAuth client configured by the user or the
+// built-in one
+func (g *Google) oauthConfig() (*oauth2.Config, error) {
+  data, err := os.ReadFile(g.SecretPath)
+  if os.IsNotExist(err) {
+    data, err = base64.RawStdEncoding.DecodeString(SECRET)
+  }
   if err != nil {
     return nil, err
   }
 
-  tok, err := config.Exchange(context.TODO(), code)
+  config, err := google.ConfigFromJSON(data, drive.DriveScope)
   if err != nil {
     return nil, err
   }
 
-  return tok, nil
+  // Client secret files don't contain the endpoint of the device flow
+  config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+
+  return config, nil
 }
 
-func getAuthorizationcodeFromRedirect() (string, error) {
-  listener, err := net.Listen("tcp", "127.0.0.1:3333")
+// SetClientSecret stores the OAuth client of the user, as downloaded from the
+// Google Cloud console.
+// Tokens belong to the client they were issued for, so the saved token is
+// removed and the account has to be authenticated again.
+func (g *Google) SetClientSecret(path string) error {
+  data, err := os.ReadFile(path)
   if err != nil {
-    return "", err
+    return err
   }
-  defer listener.Close()
 
-  con, err := listener.Accept()
+  _, err = google.ConfigFromJSON(data, drive.DriveScope)
   if err != nil {
-    return "", err
+    return fmt.Errorf("%v is not a valid client secret: %w", path, err)
   }
-  defer con.Close()
 
-  tmp := make([]byte, 1024)
-  _, err = con.Read(tmp)
+  err = writeFileAtomic(g.SecretPath, data)
   if err != nil {
-    return "", err
+    return err
   }
 
-  // Sanitize data, don´t know if needed
-  var data []byte
-  for i, v := range tmp  {
-    if (v == 0) {
-      data = tmp[0:i]
-      break
-    }
+  err = deleteToken(g.TokenName)
+  if err != nil {
+    return err
   }
 
-  reader := bufio.NewReader(bytes.NewReader(data))
-  req, err := http.ReadRequest(reader)
+  g.mu.Lock()
+  g.srv = nil
+  g.mu.Unlock()
+
+  return nil
+}
+
+// setServiceAccount stores the key of the service account and the user to
+// impersonate, when the credentials are rejected nothing is kept
+func (g *Google) setServiceAccount(path string, subject string) error {
+  key, err := os.ReadFile(path)
   if err != nil {
-    return "", err
+    return err
   }
-  defer req.Body.Close()
-  code := req.URL.Query().Get("code")
 
-  con.Write([]byte("Succes\r\nYou can close this browser window"))
+  _, err = google.JWTConfigFromJSON(key, drive.DriveScope)
+  if err != nil {
+    return fmt.Errorf("%v is not a valid service account key: %w", path, err)
+  }
 
-  return code, nil
-}
+  store, err := credentialStore()
+  if err != nil {
+    return err
+  }
+
+  err = store.Save(g.ServiceAccountName, key)
+  if err != nil {
+    return err
+  }
 
-func saveToken(path string, token *oauth2.Token) error {
-  file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+  err = SetSetting(g.settingKey(googleSubject), subject)
   if err != nil {
     return err
   }
-  defer file.Close() 
-  json.NewEncoder(file).Encode(token)
+
+  g.mu.Lock()
+  g.srv = nil
+  g.mu.Unlock()
+
+  if g.AuthState() == Reauthenticate {
+    store.Delete(g.ServiceAccountName)
+    SetSetting(g.settingKey(googleSubject), "")
+
+    g.mu.Lock()
+    g.srv = nil
+    g.mu.Unlock()
+
+    if subject != "" {
+      return fmt.Errorf("the service account is not allowed to act as %v, check the domain-wide delegation", subject)
+    }
+    return errors.New("the service account was rejected")
+  }
 
   return nil
 }
 
-func (g Google) IsAuthenticated() bool {
-  _, err := os.Open(g.TokenPath)
+// Revoke signs out of the account. The token is revoked at Google, so it
+// can't be used anymore even when a copy of it exists, and all credentials
+// are removed. They are removed as well when the revocation fails.
+func (g *Google) Revoke() error {
+  var revokeErr error
+  tok, err := readToken(g.TokenName)
+  if err == nil {
+    revokeErr = revokeToken(googleRevokeURL, tok)
+  }
+
+  err = deleteToken(g.TokenName)
   if err != nil {
-    return false
+    return err
   }
 
-  return true
-}
+  store, err := credentialStore()
+  if err != nil {
+    return err
+  }
 
-func (g Google) GetRemoteId(name string) (string, error) {
-  client, err := getClient(&g)
+  err = store.Delete(g.ServiceAccountName)
   if err != nil {
-    return "", err
+    return err
+  }
+
+  for _, key := range []string{googleSubject, googlePageToken} {
+    err = SetSetting(g.settingKey(key), "")
+    if err != nil {
+      return err
+    }
+  }
+
+  g.mu.Lock()
+  g.srv = nil
+  g.mu.Unlock()
+
+  if revokeErr != nil {
+    return fmt.Errorf("the credentials were removed, but Google could not revoke them: %w", revokeErr)
+  }
+
+  return nil
+}
+
+// AuthState checks the saved credentials with a request to Drive
+func (g *Google) AuthState() AuthState {
+  _, err := readToken(g.TokenName)
+  _, keyErr := g.readServiceAccount()
+  if errors.Is(err, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+    return Unauthenticated
   }
 
-  srv, err := drive.NewService(context.TODO(), option.WithHTTPClient(client))
+  srv, err := g.service()
+  if err == nil {
+    _, err = srv.About.Get().Fields("user").Context(g.context()).Do()
+  }
+
+  var apiErr *googleapi.Error
+  switch {
+    case err == nil:
+      return Authenticated
+    case errors.Is(err, ErrNeedsReauth), errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized:
+      return Reauthenticate
+    default:
+      return AuthUnknown
+  }
+}
+
+func (g *Google) IsAuthenticated() bool {
+  return g.AuthState() == Authenticated
+}
+
+func (g *Google) GetRemoteId(name string) (string, error) {
+  srv, err := g.service()
   if err != nil {
     return "", err
   }
 
-  r, err := srv.Files.List().Do()
+  r, err := srv.Files.List().Context(g.context()).Do()
   if err != nil {
     return "", err
   }
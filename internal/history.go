@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot is an old version of a local file, saved before it was
+// overwritten by a pull
+type Snapshot struct {
+  Version int
+  Time time.Time
+  Size int64
+  Path string
+}
+
+func historyDir(file File) string {
+  return filepath.Join(configDir, "history", strconv.Itoa(file.Id))
+}
+
+// Snapshots returns the saved versions of the file, newest first
+func Snapshots(file File) ([]Snapshot, error) {
+  entries, err := os.ReadDir(historyDir(file))
+  if os.IsNotExist(err) {
+    return []Snapshot{}, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  snapshots := make([]Snapshot, 0)
+  for _, e := range entries {
+    // Snapshots are named <version>_<unix time>
+    v, t, ok := strings.Cut(e.Name(), "_")
+    if !ok {
+      continue
+    }
+    version, err := strconv.Atoi(v)
+    if err != nil {
+      continue
+    }
+    unix, err := strconv.ParseInt(t, 10, 64)
+    if err != nil {
+      continue
+    }
+    info, err := e.Info()
+    if err != nil {
+      continue
+    }
+
+    snapshots = append(snapshots, Snapshot{
+      Version: version,
+      Time: time.Unix(unix, 0),
+      Size: info.Size(),
+      Path: filepath.Join(historyDir(file), e.Name()),
+    })
+  }
+
+  slices.SortFunc(snapshots, func(a Snapshot, b Snapshot) int {
+    return b.Version - a.Version
+  })
+
+  return snapshots, nil
+}
+
+// snapshot saves the current local file before it gets overwritten.
+// Nothing is saved when the file doesn't exist or equals the last snapshot.
+func snapshot(file File) error {
+  local, err := HashFile(file.LocalPath)
+  if err != nil || local == "" {
+    return err
+  }
+
+  snapshots, err := Snapshots(file)
+  if err != nil {
+    return err
+  }
+
+  version := 1
+  if len(snapshots) > 0 {
+    latest, err := HashFile(snapshots[0].Path)
+    if err != nil {
+      return err
+    }
+    if latest == local {
+      return nil
+    }
+    version = snapshots[0].Version + 1
+  }
+
+  err = os.MkdirAll(historyDir(file), 0700)
+  if err != nil {
+    return err
+  }
+
+  src, err := os.Open(file.LocalPath)
+  if err != nil {
+    return err
+  }
+  defer src.Close()
+
+  path := filepath.Join(historyDir(file), fmt.Sprintf("%v_%v", version, time.Now().Unix()))
+  dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+  if err != nil {
+    return err
+  }
+
+  _, err = io.Copy(dst, src)
+  if err == nil {
+    err = dst.Sync()
+  }
+  if closeErr := dst.Close(); err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    os.Remove(path)
+    return err
+  }
+
+  return pruneSnapshots(file)
+}
+
+// pruneSnapshots removes the snapshots beyond the configured number of
+// versions and days, the newest snapshot is always kept
+func pruneSnapshots(file File) error {
+  versions, err := GetIntSetting("history.versions")
+  if err != nil {
+    return err
+  }
+  days, err := GetIntSetting("history.days")
+  if err != nil {
+    return err
+  }
+
+  snapshots, err := Snapshots(file)
+  if err != nil {
+    return err
+  }
+
+  for i, s := range snapshots {
+    if i == 0 {
+      continue
+    }
+
+    tooMany := versions > 0 && i >= versions
+    tooOld := days > 0 && time.Since(s.Time) > time.Duration(days) * 24 * time.Hour
+    if !tooMany && !tooOld {
+      continue
+    }
+
+    err = os.Remove(s.Path)
+    if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// Restore replaces the local file with a snapshot, the current local file is
+// saved as a snapshot first. The restored file is pushed by the next sync.
+func Restore(file File, version int) error {
+  snapshots, err := Snapshots(file)
+  if err != nil {
+    return err
+  }
+
+  i := slices.IndexFunc(snapshots, func(s Snapshot) bool {
+    return s.Version == version
+  })
+  if i < 0 {
+    return fmt.Errorf("%v has no version %v", file.RemoteName, version)
+  }
+
+  data, err := os.ReadFile(snapshots[i].Path)
+  if err != nil {
+    return err
+  }
+
+  err = snapshot(file)
+  if err != nil {
+    return err
+  }
+
+  return writeFileAtomic(file.LocalPath, data)
+}
+
+func removeHistory(file File) error {
+  return os.RemoveAll(historyDir(file))
+}
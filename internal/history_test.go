@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func snapshotVersions(t *testing.T, file File) []int {
+  snapshots, err := Snapshots(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  versions := make([]int, 0)
+  for _, s := range snapshots {
+    versions = append(versions, s.Version)
+  }
+  return versions
+}
+
+func equalVersions(a []int, b []int) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for i := range a {
+    if a[i] != b[i] {
+      return false
+    }
+  }
+  return true
+}
+
+func TestSnapshot(t *testing.T) {
+  initTestConfig(t)
+  file := addTestFiles(t, "notes.txt")[0]
+
+  // A file that doesn't exist has nothing to keep
+  err := snapshot(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  steps := []struct {
+    content string
+    versions []int
+  }{
+    {"first", []int{1}},
+    {"first", []int{1}},
+    {"second", []int{2, 1}},
+    {"third", []int{3, 2, 1}},
+  }
+
+  for _, step := range steps {
+    err = os.WriteFile(file.LocalPath, []byte(step.content), 0600)
+    if err != nil {
+      t.Fatal(err)
+    }
+    err = snapshot(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    if versions := snapshotVersions(t, file); !equalVersions(versions, step.versions) {
+      t.Errorf("%q: versions = %v, want %v", step.content, versions, step.versions)
+    }
+  }
+
+  // Restoring keeps the current file as the newest version
+  err = Restore(file, 1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  data, err := os.ReadFile(file.LocalPath)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(data) != "first" {
+    t.Errorf("restored %q, want %q", data, "first")
+  }
+  if versions := snapshotVersions(t, file); !equalVersions(versions, []int{3, 2, 1}) {
+    t.Errorf("after restoring: versions = %v, want %v", versions, []int{3, 2, 1})
+  }
+  if err = Restore(file, 7); err == nil {
+    t.Error("restored a version that doesn't exist")
+  }
+}
+
+func TestPruneSnapshots(t *testing.T) {
+  day := 24 * time.Hour
+
+  tests := []struct {
+    name string
+    versions string
+    days string
+    // ages are the ages of the snapshots, oldest first
+    ages []time.Duration
+    kept []int
+  }{
+    {"by count", "3", "0", []time.Duration{5 * day, 4 * day, 3 * day, 2 * day, day}, []int{5, 4, 3}},
+    {"by age", "0", "30", []time.Duration{50 * day, 40 * day, 20 * day, day}, []int{4, 3}},
+    {"by count and age", "2", "30", []time.Duration{40 * day, 20 * day, 10 * day, day}, []int{4, 3}},
+    {"newest is kept", "0", "1", []time.Duration{50 * day, 40 * day, 20 * day}, []int{3}},
+    {"unlimited", "0", "0", []time.Duration{500 * day, 400 * day, 200 * day}, []int{3, 2, 1}},
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      initTestConfig(t)
+      file := addTestFiles(t, "notes.txt")[0]
+
+      for key, value := range map[string]string{"history.versions": test.versions, "history.days": test.days} {
+        err := SetSetting(key, value)
+        if err != nil {
+          t.Fatal(err)
+        }
+      }
+
+      err := os.MkdirAll(historyDir(file), 0700)
+      if err != nil {
+        t.Fatal(err)
+      }
+      for i, age := range test.ages {
+        name := fmt.Sprintf("%v_%v", i + 1, time.Now().Add(-age).Unix())
+        err = os.WriteFile(filepath.Join(historyDir(file), name), []byte(name), 0600)
+        if err != nil {
+          t.Fatal(err)
+        }
+      }
+
+      err = pruneSnapshots(file)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      if versions := snapshotVersions(t, file); !equalVersions(versions, test.kept) {
+        t.Errorf("kept %v, want %v", versions, test.kept)
+      }
+    })
+  }
+}
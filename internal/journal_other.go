@@ -0,0 +1,24 @@
+//go:build !unix
+
+package internal
+
+import "errors"
+
+// Journal is only implemented on unix, the journal is part of systemd
+type Journal struct{}
+
+func JournalStream() bool {
+  return false
+}
+
+func NewJournal(identifier string) (*Journal, error) {
+  return nil, errors.New("the journal is only available on linux")
+}
+
+func (j *Journal) Write(p []byte) (int, error) {
+  return 0, errors.New("the journal is only available on linux")
+}
+
+func (j *Journal) Close() error {
+  return nil
+}
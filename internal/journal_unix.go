@@ -0,0 +1,90 @@
+//go:build unix
+
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalStream reports if stderr is connected to the journal, systemd
+// tells so with JOURNAL_STREAM=<device>:<inode>
+func JournalStream() bool {
+  stream := os.Getenv("JOURNAL_STREAM")
+  if stream == "" {
+    return false
+  }
+
+  var stat unix.Stat_t
+  err := unix.Fstat(int(os.Stderr.Fd()), &stat)
+  if err != nil {
+    return false
+  }
+
+  return stream == fmt.Sprintf("%v:%v", stat.Dev, stat.Ino)
+}
+
+// Journal writes the log to the journal with the native protocol, every
+// message is one entry even when it has several lines
+type Journal struct {
+  conn *net.UnixConn
+  identifier string
+}
+
+// NewJournal connects to the journal, the entries are logged with the
+// identifier
+func NewJournal(identifier string) (*Journal, error) {
+  conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+  if err != nil {
+    return nil, err
+  }
+
+  return &Journal{conn: conn, identifier: identifier}, nil
+}
+
+// Write sends the message as an entry, the messages of failures are logged
+// as errors
+func (j *Journal) Write(p []byte) (int, error) {
+  message := strings.TrimRight(string(p), "\n")
+
+  priority := "6"
+  if strings.HasPrefix(message, "Unable") {
+    priority = "3"
+  }
+
+  var entry bytes.Buffer
+  journalField(&entry, "MESSAGE", message)
+  journalField(&entry, "PRIORITY", priority)
+  journalField(&entry, "SYSLOG_IDENTIFIER", j.identifier)
+
+  _, err := j.conn.Write(entry.Bytes())
+  if err != nil {
+    return 0, err
+  }
+  return len(p), nil
+}
+
+func (j *Journal) Close() error {
+  return j.conn.Close()
+}
+
+// journalField adds the field to the entry, values with a newline are
+// written with their length in front
+func journalField(entry *bytes.Buffer, name string, value string) {
+  if !strings.Contains(value, "\n") {
+    entry.WriteString(name + "=" + value + "\n")
+    return
+  }
+
+  entry.WriteString(name + "\n")
+  binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+  entry.WriteString(value + "\n")
+}
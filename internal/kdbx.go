@@ -0,0 +1,749 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/twofish"
+)
+
+// Reading and writing of KeePass KDBX 4 databases, only as far as needed to
+// merge two versions of the same database.
+// The XML document is kept as a generic tree, so everything this code does
+// not know about is written back untouched.
+
+const (
+  kdbxSignature1 = 0x9AA2D903
+  kdbxSignature2 = 0xB54BFB67
+
+  kdbxHeaderEnd = 0
+  kdbxHeaderCipher = 2
+  kdbxHeaderCompression = 3
+  kdbxHeaderMasterSeed = 4
+  kdbxHeaderIV = 7
+  kdbxHeaderKdf = 11
+
+  kdbxInnerEnd = 0
+  kdbxInnerStreamId = 1
+  kdbxInnerStreamKey = 2
+  kdbxInnerBinary = 3
+
+  kdbxStreamChaCha20 = 3
+
+  kdbxBlockSize = 1024 * 1024
+)
+
+var (
+  kdbxCipherAES = mustHex("31c1f2e6bf714350be5805216afc5aff")
+  kdbxCipherTwofish = mustHex("ad68f29f576f4bb9a36ad47af965346c")
+  kdbxCipherChaCha20 = mustHex("d6038a2b8b6f4cb5a524339a31dbb59a")
+
+  kdbxKdfAES = mustHex("c9d9f39a628a4460bf740d08c18a4fea")
+  kdbxKdfArgon2d = mustHex("ef636ddf8c29444b91f7a9a403e30a0c")
+  kdbxKdfArgon2id = mustHex("9e298b1956db4773b23dfc3ec6f0a1e6")
+
+  errKdbxCredentials = errors.New("invalid credentials or corrupted database")
+)
+
+type kdbxField struct {
+  id byte
+  data []byte
+}
+
+type kdbxBinary struct {
+  flags byte
+  data []byte
+}
+
+type kdbxDatabase struct {
+  version uint32
+  header []kdbxField
+  binaries []kdbxBinary
+  root *xmlNode
+
+  // transformedKey is the result of the key derivation, it stays valid as
+  // long as the KDF parameters in the header are unchanged
+  transformedKey []byte
+}
+
+// xmlNode is an element of the XML document, protected values are kept
+// decrypted in Text
+type xmlNode struct {
+  Name string
+  Attrs []xml.Attr
+  Text string
+  Children []*xmlNode
+}
+
+// kdbxCompositeKey combines the password and the key file to the key
+// KeePass uses to derive the database keys from
+func kdbxCompositeKey(password string, keyFile string) ([]byte, error) {
+  h := sha256.New()
+
+  if password != "" {
+    p := sha256.Sum256([]byte(password))
+    h.Write(p[:])
+  }
+
+  if keyFile != "" {
+    data, err := os.ReadFile(keyFile)
+    if err != nil {
+      return nil, err
+    }
+
+    key, err := kdbxKeyFileData(data)
+    if err != nil {
+      return nil, err
+    }
+    h.Write(key)
+  }
+
+  return h.Sum(nil), nil
+}
+
+func kdbxKeyFileData(data []byte) ([]byte, error) {
+  var keyFile struct {
+    Meta struct {
+      Version string
+    }
+    Key struct {
+      Data struct {
+        Text string `xml:",chardata"`
+      }
+    }
+  }
+
+  if xml.Unmarshal(data, &keyFile) == nil && keyFile.Key.Data.Text != "" {
+    text := strings.Join(strings.Fields(keyFile.Key.Data.Text), "")
+    if strings.HasPrefix(keyFile.Meta.Version, "2.") {
+      return hex.DecodeString(text)
+    }
+    return base64.StdEncoding.DecodeString(text)
+  }
+
+  if len(data) == 32 {
+    return data, nil
+  }
+
+  if len(data) == 64 {
+    key, err := hex.DecodeString(string(data))
+    if err == nil {
+      return key, nil
+    }
+  }
+
+  sum := sha256.Sum256(data)
+  return sum[:], nil
+}
+
+func readKdbx(data []byte, compositeKey []byte) (*kdbxDatabase, error) {
+  if len(data) < 12 || binary.LittleEndian.Uint32(data[0:]) != kdbxSignature1 || binary.LittleEndian.Uint32(data[4:]) != kdbxSignature2 {
+    return nil, errors.New("not a KeePass database")
+  }
+
+  db := &kdbxDatabase{
+    version: binary.LittleEndian.Uint32(data[8:]),
+  }
+  if db.version >> 16 != 4 {
+    return nil, fmt.Errorf("KDBX version %v.%v is not supported, only KDBX 4 is", db.version >> 16, db.version & 0xffff)
+  }
+
+  // Outer header
+  pos := 12
+  fields := make(map[byte][]byte)
+  for {
+    if pos + 5 > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    id := data[pos]
+    size := int(binary.LittleEndian.Uint32(data[pos + 1:]))
+    pos += 5
+    if pos + size > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    value := data[pos : pos + size]
+    pos += size
+
+    if id == kdbxHeaderEnd {
+      break
+    }
+    db.header = append(db.header, kdbxField{id, value})
+    fields[id] = value
+  }
+  header := data[:pos]
+
+  if pos + 64 > len(data) {
+    return nil, io.ErrUnexpectedEOF
+  }
+  sum := sha256.Sum256(header)
+  if !hmac.Equal(sum[:], data[pos : pos + 32]) {
+    return nil, errors.New("corrupted database header")
+  }
+
+  kdf, err := parseVariantDictionary(fields[kdbxHeaderKdf])
+  if err != nil {
+    return nil, err
+  }
+  db.transformedKey, err = kdbxTransformKey(kdf, compositeKey)
+  if err != nil {
+    return nil, err
+  }
+
+  seed := fields[kdbxHeaderMasterSeed]
+  hmacKey := kdbxHmacKey(seed, db.transformedKey)
+  if !hmac.Equal(kdbxBlockHmac(hmacKey, ^uint64(0), header), data[pos + 32 : pos + 64]) {
+    return nil, errKdbxCredentials
+  }
+  pos += 64
+
+  // HMAC protected blocks
+  var payload bytes.Buffer
+  for index := uint64(0); ; index++ {
+    if pos + 36 > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    mac := data[pos : pos + 32]
+    size := int(binary.LittleEndian.Uint32(data[pos + 32:]))
+    if pos + 36 + size > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    block := data[pos + 32 : pos + 36 + size]
+    pos += 36 + size
+
+    if !hmac.Equal(mac, kdbxBlockHmac(hmacKey, index, append(le64(index), block...))) {
+      return nil, errors.New("corrupted database content")
+    }
+    if size == 0 {
+      break
+    }
+    payload.Write(block[4:])
+  }
+
+  key := sha256.Sum256(append(append([]byte{}, seed...), db.transformedKey...))
+  content, err := kdbxCrypt(fields[kdbxHeaderCipher], key[:], fields[kdbxHeaderIV], payload.Bytes(), false)
+  if err != nil {
+    return nil, err
+  }
+
+  if compression := fields[kdbxHeaderCompression]; len(compression) == 4 && binary.LittleEndian.Uint32(compression) == 1 {
+    r, err := gzip.NewReader(bytes.NewReader(content))
+    if err != nil {
+      return nil, err
+    }
+    content, err = io.ReadAll(r)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  // Inner header
+  var streamId uint32
+  var streamKey []byte
+  pos = 0
+  for {
+    if pos + 5 > len(content) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    id := content[pos]
+    size := int(binary.LittleEndian.Uint32(content[pos + 1:]))
+    pos += 5
+    if pos + size > len(content) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    value := content[pos : pos + size]
+    pos += size
+
+    switch id {
+      case kdbxInnerStreamId:
+        streamId = binary.LittleEndian.Uint32(value)
+      case kdbxInnerStreamKey:
+        streamKey = value
+      case kdbxInnerBinary:
+        if size < 1 {
+          return nil, errors.New("invalid binary in database")
+        }
+        db.binaries = append(db.binaries, kdbxBinary{value[0], append([]byte{}, value[1:]...)})
+    }
+    if id == kdbxInnerEnd {
+      break
+    }
+  }
+
+  if streamId != kdbxStreamChaCha20 {
+    return nil, fmt.Errorf("inner stream cipher %v is not supported", streamId)
+  }
+  stream, err := kdbxInnerStream(streamKey)
+  if err != nil {
+    return nil, err
+  }
+
+  db.root, err = parseKdbxXml(content[pos:], stream)
+  if err != nil {
+    return nil, err
+  }
+
+  return db, nil
+}
+
+// write serializes the database with a new master seed, IV and inner
+// stream key, but with the same credentials and KDF parameters
+func (db *kdbxDatabase) write() ([]byte, error) {
+  fields := make(map[byte][]byte)
+  for i, f := range db.header {
+    switch f.id {
+      case kdbxHeaderMasterSeed, kdbxHeaderIV:
+        value := make([]byte, len(f.data))
+        _, err := rand.Read(value)
+        if err != nil {
+          return nil, err
+        }
+        db.header[i].data = value
+    }
+    fields[f.id] = db.header[i].data
+  }
+
+  var out bytes.Buffer
+  out.Write(le32(kdbxSignature1))
+  out.Write(le32(kdbxSignature2))
+  out.Write(le32(db.version))
+  for _, f := range db.header {
+    out.WriteByte(f.id)
+    out.Write(le32(uint32(len(f.data))))
+    out.Write(f.data)
+  }
+  out.WriteByte(kdbxHeaderEnd)
+  out.Write(le32(4))
+  out.WriteString("\r\n\r\n")
+  header := append([]byte{}, out.Bytes()...)
+
+  seed := fields[kdbxHeaderMasterSeed]
+  hmacKey := kdbxHmacKey(seed, db.transformedKey)
+  sum := sha256.Sum256(header)
+  out.Write(sum[:])
+  out.Write(kdbxBlockHmac(hmacKey, ^uint64(0), header))
+
+  // Inner header and XML
+  streamKey := make([]byte, 64)
+  _, err := rand.Read(streamKey)
+  if err != nil {
+    return nil, err
+  }
+
+  var content bytes.Buffer
+  writeInnerField := func(id byte, data ...[]byte) {
+    size := 0
+    for _, d := range data {
+      size += len(d)
+    }
+    content.WriteByte(id)
+    content.Write(le32(uint32(size)))
+    for _, d := range data {
+      content.Write(d)
+    }
+  }
+  writeInnerField(kdbxInnerStreamId, le32(kdbxStreamChaCha20))
+  writeInnerField(kdbxInnerStreamKey, streamKey)
+  for _, b := range db.binaries {
+    writeInnerField(kdbxInnerBinary, []byte{b.flags}, b.data)
+  }
+  writeInnerField(kdbxInnerEnd)
+
+  stream, err := kdbxInnerStream(streamKey)
+  if err != nil {
+    return nil, err
+  }
+  err = writeKdbxXml(&content, db.root, stream)
+  if err != nil {
+    return nil, err
+  }
+
+  plain := content.Bytes()
+  if compression := fields[kdbxHeaderCompression]; len(compression) == 4 && binary.LittleEndian.Uint32(compression) == 1 {
+    var compressed bytes.Buffer
+    w := gzip.NewWriter(&compressed)
+    _, err = w.Write(plain)
+    if err != nil {
+      return nil, err
+    }
+    err = w.Close()
+    if err != nil {
+      return nil, err
+    }
+    plain = compressed.Bytes()
+  }
+
+  key := sha256.Sum256(append(append([]byte{}, seed...), db.transformedKey...))
+  payload, err := kdbxCrypt(fields[kdbxHeaderCipher], key[:], fields[kdbxHeaderIV], plain, true)
+  if err != nil {
+    return nil, err
+  }
+
+  for index := uint64(0); ; index++ {
+    size := min(len(payload), kdbxBlockSize)
+    block := append(le32(uint32(size)), payload[:size]...)
+    payload = payload[size:]
+
+    out.Write(kdbxBlockHmac(hmacKey, index, append(le64(index), block...)))
+    out.Write(block)
+
+    if size == 0 {
+      break
+    }
+  }
+
+  return out.Bytes(), nil
+}
+
+func kdbxTransformKey(kdf map[string][]byte, compositeKey []byte) ([]byte, error) {
+  uuid := kdf["$UUID"]
+
+  switch {
+    case bytes.Equal(uuid, kdbxKdfArgon2d), bytes.Equal(uuid, kdbxKdfArgon2id):
+      if len(kdf["P"]) != 4 || len(kdf["M"]) != 8 || len(kdf["I"]) != 8 {
+        return nil, errors.New("invalid Argon2 parameters")
+      }
+      if version := kdf["V"]; len(version) != 4 || binary.LittleEndian.Uint32(version) != 0x13 {
+        return nil, errors.New("only Argon2 version 1.3 is supported")
+      }
+
+      mode := argon2d
+      if bytes.Equal(uuid, kdbxKdfArgon2id) {
+        mode = argon2id
+      }
+      lanes := binary.LittleEndian.Uint32(kdf["P"])
+      memory := binary.LittleEndian.Uint64(kdf["M"]) / 1024
+      passes := binary.LittleEndian.Uint64(kdf["I"])
+
+      return argon2Key(mode, compositeKey, kdf["S"], uint32(passes), uint32(memory), lanes, 32), nil
+    case bytes.Equal(uuid, kdbxKdfAES):
+      if len(kdf["R"]) != 8 {
+        return nil, errors.New("invalid AES-KDF parameters")
+      }
+      block, err := aes.NewCipher(kdf["S"])
+      if err != nil {
+        return nil, err
+      }
+
+      key := append([]byte{}, compositeKey...)
+      for rounds := binary.LittleEndian.Uint64(kdf["R"]); rounds > 0; rounds-- {
+        block.Encrypt(key[0:16], key[0:16])
+        block.Encrypt(key[16:32], key[16:32])
+      }
+      sum := sha256.Sum256(key)
+
+      return sum[:], nil
+    default:
+      return nil, fmt.Errorf("key derivation function %x is not supported", uuid)
+  }
+}
+
+// parseVariantDictionary returns the raw values of a KDBX variant dictionary
+func parseVariantDictionary(data []byte) (map[string][]byte, error) {
+  if len(data) < 2 || data[1] != 1 {
+    return nil, errors.New("invalid KDF parameters")
+  }
+
+  values := make(map[string][]byte)
+  pos := 2
+  for pos < len(data) && data[pos] != 0 {
+    if pos + 5 > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    keySize := int(binary.LittleEndian.Uint32(data[pos + 1:]))
+    pos += 5
+    if pos + keySize + 4 > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    key := string(data[pos : pos + keySize])
+    pos += keySize
+
+    valueSize := int(binary.LittleEndian.Uint32(data[pos:]))
+    pos += 4
+    if pos + valueSize > len(data) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    values[key] = data[pos : pos + valueSize]
+    pos += valueSize
+  }
+
+  return values, nil
+}
+
+func kdbxHmacKey(seed []byte, transformedKey []byte) []byte {
+  h := sha512.New()
+  h.Write(seed)
+  h.Write(transformedKey)
+  h.Write([]byte{1})
+  return h.Sum(nil)
+}
+
+func kdbxBlockHmac(hmacKey []byte, index uint64, data []byte) []byte {
+  key := sha512.Sum512(append(le64(index), hmacKey...))
+  mac := hmac.New(sha256.New, key[:])
+  mac.Write(data)
+  return mac.Sum(nil)
+}
+
+func kdbxCrypt(id []byte, key []byte, iv []byte, data []byte, encrypt bool) ([]byte, error) {
+  if bytes.Equal(id, kdbxCipherChaCha20) {
+    stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+    if err != nil {
+      return nil, err
+    }
+    out := make([]byte, len(data))
+    stream.XORKeyStream(out, data)
+    return out, nil
+  }
+
+  var block cipher.Block
+  var err error
+  switch {
+    case bytes.Equal(id, kdbxCipherAES):
+      block, err = aes.NewCipher(key)
+    case bytes.Equal(id, kdbxCipherTwofish):
+      block, err = twofish.NewCipher(key)
+    default:
+      return nil, fmt.Errorf("cipher %x is not supported", id)
+  }
+  if err != nil {
+    return nil, err
+  }
+  if len(iv) != block.BlockSize() {
+    return nil, errors.New("invalid encryption IV")
+  }
+
+  size := block.BlockSize()
+  if encrypt {
+    padding := size - len(data) % size
+    out := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+    cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+    return out, nil
+  }
+
+  if len(data) == 0 || len(data) % size != 0 {
+    return nil, errKdbxCredentials
+  }
+  out := make([]byte, len(data))
+  cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+  padding := int(out[len(out) - 1])
+  if padding < 1 || padding > size {
+    return nil, errKdbxCredentials
+  }
+
+  return out[:len(out) - padding], nil
+}
+
+func kdbxInnerStream(key []byte) (cipher.Stream, error) {
+  sum := sha512.Sum512(key)
+  return chacha20.NewUnauthenticatedCipher(sum[:32], sum[32:44])
+}
+
+func parseKdbxXml(data []byte, stream cipher.Stream) (*xmlNode, error) {
+  decoder := xml.NewDecoder(bytes.NewReader(data))
+
+  var root *xmlNode
+  stack := make([]*xmlNode, 0)
+  for {
+    token, err := decoder.Token()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return nil, err
+    }
+
+    switch t := token.(type) {
+      case xml.StartElement:
+        node := &xmlNode{
+          Name: t.Name.Local,
+          Attrs: t.Copy().Attr,
+        }
+        if len(stack) > 0 {
+          parent := stack[len(stack) - 1]
+          parent.Children = append(parent.Children, node)
+        } else {
+          root = node
+        }
+        stack = append(stack, node)
+      case xml.CharData:
+        if len(stack) > 0 {
+          stack[len(stack) - 1].Text += string(t)
+        }
+      case xml.EndElement:
+        node := stack[len(stack) - 1]
+        stack = stack[:len(stack) - 1]
+
+        if len(node.Children) > 0 {
+          node.Text = ""
+        } else if node.protected() {
+          value, err := base64.StdEncoding.DecodeString(node.Text)
+          if err != nil {
+            return nil, err
+          }
+          stream.XORKeyStream(value, value)
+          node.Text = string(value)
+        }
+    }
+  }
+
+  if root == nil || root.Name != "KeePassFile" {
+    return nil, errors.New("invalid database content")
+  }
+
+  return root, nil
+}
+
+func writeKdbxXml(w io.Writer, root *xmlNode, stream cipher.Stream) error {
+  _, err := io.WriteString(w, `<?xml version="1.0" encoding="utf-8" standalone="yes"?>` + "\n")
+  if err != nil {
+    return err
+  }
+
+  encoder := xml.NewEncoder(w)
+  encoder.Indent("", "\t")
+
+  var encode func(node *xmlNode) error
+  encode = func(node *xmlNode) error {
+    err := encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: node.Name}, Attr: node.Attrs})
+    if err != nil {
+      return err
+    }
+
+    if len(node.Children) == 0 && node.Text != "" {
+      text := node.Text
+      if node.protected() {
+        value := []byte(node.Text)
+        stream.XORKeyStream(value, value)
+        text = base64.StdEncoding.EncodeToString(value)
+      }
+      err = encoder.EncodeToken(xml.CharData(text))
+      if err != nil {
+        return err
+      }
+    }
+
+    for _, child := range node.Children {
+      err = encode(child)
+      if err != nil {
+        return err
+      }
+    }
+
+    return encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: node.Name}})
+  }
+
+  err = encode(root)
+  if err != nil {
+    return err
+  }
+
+  return encoder.Flush()
+}
+
+func (n *xmlNode) protected() bool {
+  return n.attr("Protected") == "True"
+}
+
+func (n *xmlNode) attr(name string) string {
+  for _, a := range n.Attrs {
+    if a.Name.Local == name {
+      return a.Value
+    }
+  }
+  return ""
+}
+
+func (n *xmlNode) setAttr(name string, value string) {
+  for i, a := range n.Attrs {
+    if a.Name.Local == name {
+      n.Attrs[i].Value = value
+      return
+    }
+  }
+  n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+  for _, c := range n.Children {
+    if c.Name == name {
+      return c
+    }
+  }
+  return nil
+}
+
+func (n *xmlNode) childText(name string) string {
+  c := n.child(name)
+  if c == nil {
+    return ""
+  }
+  return c.Text
+}
+
+func (n *xmlNode) clone() *xmlNode {
+  c := &xmlNode{
+    Name: n.Name,
+    Attrs: append([]xml.Attr{}, n.Attrs...),
+    Text: n.Text,
+  }
+  for _, child := range n.Children {
+    c.Children = append(c.Children, child.clone())
+  }
+  return c
+}
+
+func (n *xmlNode) remove(child *xmlNode) {
+  for i, c := range n.Children {
+    if c == child {
+      n.Children = append(n.Children[:i], n.Children[i + 1:]...)
+      return
+    }
+  }
+}
+
+// parseKdbxTime reads a KDBX 4 time, which is the base64 encoded number of
+// seconds since 0001-01-01, or an ISO 8601 time as older versions used
+func parseKdbxTime(text string) time.Time {
+  t, err := time.Parse(time.RFC3339, text)
+  if err == nil {
+    return t
+  }
+
+  data, err := base64.StdEncoding.DecodeString(text)
+  if err != nil || len(data) != 8 {
+    return time.Time{}
+  }
+
+  return time.Unix(int64(binary.LittleEndian.Uint64(data)) - 62135596800, 0).UTC()
+}
+
+func le64(v uint64) []byte {
+  b := make([]byte, 8)
+  binary.LittleEndian.PutUint64(b, v)
+  return b
+}
+
+func mustHex(s string) []byte {
+  b, err := hex.DecodeString(s)
+  if err != nil {
+    panic(err)
+  }
+  return b
+}
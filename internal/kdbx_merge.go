@@ -0,0 +1,422 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// kdbxMerge merges two versions of a KeePass database by the UUID of every
+// group and entry. The most recently modified version of an entry wins and
+// the other version is kept in its history, so no edit is ever lost.
+type kdbxMerge struct{}
+
+type kdbxItem struct {
+  node *xmlNode
+  parent *xmlNode
+}
+
+type kdbxMerger struct {
+  local *kdbxDatabase
+  remote *kdbxDatabase
+  root *xmlNode
+  items map[string]kdbxItem
+  binaries map[string]string
+  deleted map[string]time.Time
+}
+
+func (kdbxMerge) NeedsBase() bool {
+  return false
+}
+
+func (kdbxMerge) Merge(input MergeInput) ([]byte, error) {
+  if input.Options.Password == "" && input.Options.KeyFile == "" {
+    return nil, ErrKeyRequired
+  }
+
+  key, err := kdbxCompositeKey(input.Options.Password, input.Options.KeyFile)
+  if err != nil {
+    return nil, err
+  }
+
+  local, err := readKdbx(input.Local, key)
+  if err != nil {
+    return nil, err
+  }
+
+  remote, err := readKdbx(input.Remote, key)
+  if err != nil {
+    return nil, err
+  }
+
+  err = mergeKdbx(local, remote)
+  if err != nil {
+    return nil, err
+  }
+
+  return local.write()
+}
+
+// mergeKdbx merges the remote database into the local one
+func mergeKdbx(local *kdbxDatabase, remote *kdbxDatabase) error {
+  localRoot := local.root.child("Root")
+  remoteRoot := remote.root.child("Root")
+  if localRoot == nil || remoteRoot == nil || localRoot.child("Group") == nil || remoteRoot.child("Group") == nil {
+    return errors.New("invalid database content")
+  }
+
+  m := &kdbxMerger{
+    local: local,
+    remote: remote,
+    root: localRoot.child("Group"),
+    items: make(map[string]kdbxItem),
+    binaries: make(map[string]string),
+    deleted: make(map[string]time.Time),
+  }
+
+  if m.root.childText("UUID") != remoteRoot.child("Group").childText("UUID") {
+    return errors.New("the databases are not versions of the same database")
+  }
+
+  m.index(m.root, nil)
+  m.mergeDeletedObjects(localRoot, remoteRoot)
+  m.mergeCustomIcons(local.root.child("Meta"), remote.root.child("Meta"))
+  m.mergeGroupChildren(remoteRoot.child("Group"))
+  m.applyDeletions(m.root)
+
+  return nil
+}
+
+func (m *kdbxMerger) index(group *xmlNode, parent *xmlNode) {
+  m.items[group.childText("UUID")] = kdbxItem{group, parent}
+
+  for _, c := range group.Children {
+    switch c.Name {
+      case "Group":
+        m.index(c, group)
+      case "Entry":
+        m.items[c.childText("UUID")] = kdbxItem{c, group}
+    }
+  }
+}
+
+func (m *kdbxMerger) mergeDeletedObjects(localRoot *xmlNode, remoteRoot *xmlNode) {
+  deletedObjects := localRoot.child("DeletedObjects")
+  if deletedObjects == nil {
+    deletedObjects = &xmlNode{Name: "DeletedObjects"}
+    localRoot.Children = append(localRoot.Children, deletedObjects)
+  }
+
+  for _, d := range deletedObjects.Children {
+    m.deleted[d.childText("UUID")] = parseKdbxTime(d.childText("DeletionTime"))
+  }
+
+  if remoteDeleted := remoteRoot.child("DeletedObjects"); remoteDeleted != nil {
+    for _, d := range remoteDeleted.Children {
+      uuid := d.childText("UUID")
+      t := parseKdbxTime(d.childText("DeletionTime"))
+
+      existing, ok := m.deleted[uuid]
+      if !ok {
+        deletedObjects.Children = append(deletedObjects.Children, d.clone())
+      }
+      if !ok || t.After(existing) {
+        m.deleted[uuid] = t
+      }
+    }
+  }
+}
+
+func (m *kdbxMerger) mergeCustomIcons(localMeta *xmlNode, remoteMeta *xmlNode) {
+  if localMeta == nil || remoteMeta == nil || remoteMeta.child("CustomIcons") == nil {
+    return
+  }
+
+  icons := localMeta.child("CustomIcons")
+  if icons == nil {
+    icons = &xmlNode{Name: "CustomIcons"}
+    localMeta.Children = append(localMeta.Children, icons)
+  }
+
+  known := make(map[string]bool)
+  for _, icon := range icons.Children {
+    known[icon.childText("UUID")] = true
+  }
+
+  for _, icon := range remoteMeta.child("CustomIcons").Children {
+    if !known[icon.childText("UUID")] {
+      icons.Children = append(icons.Children, icon.clone())
+    }
+  }
+}
+
+// mergeGroupChildren walks the remote group top down, so a parent group
+// always exists locally before its children are merged
+func (m *kdbxMerger) mergeGroupChildren(remoteGroup *xmlNode) {
+  for _, c := range remoteGroup.Children {
+    switch c.Name {
+      case "Group":
+        m.mergeGroup(c, remoteGroup)
+        m.mergeGroupChildren(c)
+      case "Entry":
+        m.mergeEntry(c, remoteGroup)
+    }
+  }
+}
+
+func (m *kdbxMerger) mergeGroup(remote *xmlNode, remoteParent *xmlNode) {
+  uuid := remote.childText("UUID")
+
+  local, ok := m.items[uuid]
+  if !ok {
+    if m.isDeleted(uuid, remote) {
+      return
+    }
+
+    group := &xmlNode{
+      Name: remote.Name,
+      Attrs: remote.Attrs,
+    }
+    for _, c := range remote.Children {
+      if c.Name != "Group" && c.Name != "Entry" {
+        group.Children = append(group.Children, c.clone())
+      }
+    }
+
+    parent := m.parent(remoteParent)
+    parent.Children = append(parent.Children, group)
+    m.items[uuid] = kdbxItem{group, parent}
+    return
+  }
+
+  location := kdbxTimes(local.node, "LocationChanged")
+  if kdbxTimes(remote, "LastModificationTime").After(kdbxTimes(local.node, "LastModificationTime")) {
+    children := make([]*xmlNode, 0)
+    for _, c := range remote.Children {
+      if c.Name != "Group" && c.Name != "Entry" {
+        children = append(children, c.clone())
+      }
+    }
+    for _, c := range local.node.Children {
+      if c.Name == "Group" || c.Name == "Entry" {
+        children = append(children, c)
+      }
+    }
+    local.node.Children = children
+  }
+
+  m.move(uuid, location, remote, remoteParent)
+}
+
+func (m *kdbxMerger) mergeEntry(remote *xmlNode, remoteParent *xmlNode) {
+  uuid := remote.childText("UUID")
+
+  local, ok := m.items[uuid]
+  if !ok {
+    if m.isDeleted(uuid, remote) {
+      return
+    }
+
+    entry := m.copyRemote(remote)
+    parent := m.parent(remoteParent)
+    insertEntry(parent, entry)
+    m.items[uuid] = kdbxItem{entry, parent}
+    return
+  }
+
+  remoteEntry := m.copyRemote(remote)
+  location := kdbxTimes(local.node, "LocationChanged")
+  localTime := kdbxTimes(local.node, "LastModificationTime")
+  remoteTime := kdbxTimes(remoteEntry, "LastModificationTime")
+
+  current, older := local.node, remoteEntry
+  if remoteTime.After(localTime) {
+    current, older = remoteEntry, local.node
+  }
+
+  history := make([]*xmlNode, 0)
+  for _, e := range []*xmlNode{local.node, remoteEntry} {
+    if h := e.child("History"); h != nil {
+      history = append(history, h.Children...)
+    }
+  }
+  if !localTime.Equal(remoteTime) {
+    snapshot := older.clone()
+    if h := snapshot.child("History"); h != nil {
+      snapshot.remove(h)
+    }
+    history = append(history, snapshot)
+  }
+  setHistory(current, history)
+
+  if current != local.node {
+    for i, c := range local.parent.Children {
+      if c == local.node {
+        local.parent.Children[i] = current
+      }
+    }
+    m.items[uuid] = kdbxItem{current, local.parent}
+  }
+
+  m.move(uuid, location, remote, remoteParent)
+}
+
+// move puts the local item in the group the remote version is in, when the
+// remote version moved it more recently than the local one was moved
+func (m *kdbxMerger) move(uuid string, location time.Time, remote *xmlNode, remoteParent *xmlNode) {
+  local := m.items[uuid]
+  if local.parent == nil || remoteParent == nil {
+    return
+  }
+
+  parent, ok := m.items[remoteParent.childText("UUID")]
+  if !ok || parent.node == local.parent {
+    return
+  }
+
+  if !kdbxTimes(remote, "LocationChanged").After(location) {
+    return
+  }
+
+  // Never move a group into itself
+  for p := parent.node; p != nil; p = m.items[p.childText("UUID")].parent {
+    if p == local.node {
+      return
+    }
+  }
+
+  local.parent.remove(local.node)
+  if local.node.Name == "Entry" {
+    insertEntry(parent.node, local.node)
+  } else {
+    parent.node.Children = append(parent.node.Children, local.node)
+  }
+  m.items[uuid] = kdbxItem{local.node, parent.node}
+}
+
+// applyDeletions removes the groups and entries that were deleted on either
+// side after they were last modified
+func (m *kdbxMerger) applyDeletions(group *xmlNode) {
+  children := make([]*xmlNode, 0, len(group.Children))
+  for _, c := range group.Children {
+    if (c.Name == "Group" || c.Name == "Entry") && m.isDeleted(c.childText("UUID"), c) {
+      continue
+    }
+    if c.Name == "Group" {
+      m.applyDeletions(c)
+    }
+    children = append(children, c)
+  }
+  group.Children = children
+}
+
+func (m *kdbxMerger) isDeleted(uuid string, node *xmlNode) bool {
+  t, ok := m.deleted[uuid]
+  return ok && !t.Before(kdbxTimes(node, "LastModificationTime"))
+}
+
+// parent returns the local group for the remote parent group, or the root
+// group when it does not exist locally
+func (m *kdbxMerger) parent(remoteParent *xmlNode) *xmlNode {
+  item, ok := m.items[remoteParent.childText("UUID")]
+  if !ok || item.node.Name != "Group" {
+    return m.root
+  }
+  return item.node
+}
+
+// copyRemote clones a remote entry and moves the attachments it references
+// into the binary pool of the local database
+func (m *kdbxMerger) copyRemote(entry *xmlNode) *xmlNode {
+  c := entry.clone()
+
+  var remap func(n *xmlNode)
+  remap = func(n *xmlNode) {
+    if n.Name == "Binary" {
+      if v := n.child("Value"); v != nil && v.attr("Ref") != "" {
+        v.setAttr("Ref", m.binary(v.attr("Ref")))
+      }
+    }
+    for _, child := range n.Children {
+      remap(child)
+    }
+  }
+  remap(c)
+
+  return c
+}
+
+func (m *kdbxMerger) binary(ref string) string {
+  if r, ok := m.binaries[ref]; ok {
+    return r
+  }
+
+  index, err := strconv.Atoi(ref)
+  if err != nil || index < 0 || index >= len(m.remote.binaries) {
+    return ref
+  }
+  b := m.remote.binaries[index]
+
+  local := slices.IndexFunc(m.local.binaries, func(l kdbxBinary) bool {
+    return l.flags == b.flags && bytes.Equal(l.data, b.data)
+  })
+  if local < 0 {
+    m.local.binaries = append(m.local.binaries, b)
+    local = len(m.local.binaries) - 1
+  }
+
+  m.binaries[ref] = strconv.Itoa(local)
+  return m.binaries[ref]
+}
+
+// insertEntry adds the entry after the existing entries of the group, as
+// KeePass writes the entries of a group before its subgroups
+func insertEntry(group *xmlNode, entry *xmlNode) {
+  i := slices.IndexFunc(group.Children, func(c *xmlNode) bool {
+    return c.Name == "Group"
+  })
+  if i < 0 {
+    group.Children = append(group.Children, entry)
+    return
+  }
+  group.Children = slices.Insert(group.Children, i, entry)
+}
+
+// setHistory replaces the history of the entry with the given versions,
+// without duplicates and ordered from old to new
+func setHistory(entry *xmlNode, versions []*xmlNode) {
+  seen := make(map[int64]bool)
+  history := make([]*xmlNode, 0)
+  for _, v := range versions {
+    t := kdbxTimes(v, "LastModificationTime").Unix()
+    if seen[t] {
+      continue
+    }
+    seen[t] = true
+    history = append(history, v)
+  }
+
+  slices.SortStableFunc(history, func(a *xmlNode, b *xmlNode) int {
+    return kdbxTimes(a, "LastModificationTime").Compare(kdbxTimes(b, "LastModificationTime"))
+  })
+
+  h := entry.child("History")
+  if h == nil {
+    if len(history) == 0 {
+      return
+    }
+    h = &xmlNode{Name: "History"}
+    entry.Children = append(entry.Children, h)
+  }
+  h.Children = history
+}
+
+func kdbxTimes(node *xmlNode, name string) time.Time {
+  times := node.child("Times")
+  if times == nil {
+    return time.Time{}
+  }
+  return parseKdbxTime(times.childText(name))
+}
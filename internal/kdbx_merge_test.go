@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"testing"
+)
+
+const (
+  testUuidA = "ZW50cnktMDAwMDAwMDAwQQ=="
+  testUuidB = "ZW50cnktMDAwMDAwMDAwQg=="
+  testUuidC = "ZW50cnktMDAwMDAwMDAwQw=="
+)
+
+func testKdbxWithHistory(entry *xmlNode, history ...*xmlNode) *xmlNode {
+  entry.Children = append(entry.Children, testKdbxNode("History", "", history...))
+  return entry
+}
+
+func testKdbxEntries(db *kdbxDatabase) map[string]*xmlNode {
+  entries := make(map[string]*xmlNode)
+  for _, c := range db.root.child("Root").child("Group").Children {
+    if c.Name == "Entry" {
+      entries[c.childText("UUID")] = c
+    }
+  }
+  return entries
+}
+
+func testKdbxTitle(entry *xmlNode) string {
+  for _, c := range entry.Children {
+    if c.Name == "String" && c.childText("Key") == "Title" {
+      return c.childText("Value")
+    }
+  }
+  return ""
+}
+
+func TestKdbxMerge(t *testing.T) {
+  key, _ := kdbxCompositeKey("secret", "")
+  original := testKdbxEntry(testUuidA, "2024-02-01T00:00:00Z", "original", "one")
+
+  local := testKdbxRoot(
+    testKdbxWithHistory(testKdbxEntry(testUuidA, "2024-03-01T00:00:00Z", "local", "two"), original.clone()),
+    testKdbxEntry(testUuidC, "2024-01-15T00:00:00Z", "deleted remotely", "three"),
+  )
+  remote := testKdbxRoot(
+    testKdbxWithHistory(testKdbxEntry(testUuidA, "2024-03-02T00:00:00Z", "remote", "four"), original.clone()),
+    testKdbxEntry(testUuidB, "2024-03-02T00:00:00Z", "added remotely", "five"),
+  )
+  remote.child("Root").Children = append(remote.child("Root").Children, testKdbxNode("DeletedObjects", "",
+    testKdbxNode("DeletedObject", "",
+      testKdbxNode("UUID", testUuidC),
+      testKdbxNode("DeletionTime", "2024-02-15T00:00:00Z"),
+    ),
+  ))
+
+  localData, err := newTestKdbx(t, kdbxCipherChaCha20, key, local).write()
+  if err != nil {
+    t.Fatal(err)
+  }
+  remoteData, err := newTestKdbx(t, kdbxCipherChaCha20, key, remote).write()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  merged, err := kdbxMerge{}.Merge(MergeInput{Local: localData, Remote: remoteData, Options: MergeOptions{Password: "secret"}})
+  if err != nil {
+    t.Fatal(err)
+  }
+  db, err := readKdbx(merged, key)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  entries := testKdbxEntries(db)
+  if len(entries) != 2 || entries[testUuidA] == nil || entries[testUuidB] == nil {
+    t.Fatalf("merged entries = %v, want the edited and the added entry", entries)
+  }
+
+  a := entries[testUuidA]
+  if got := testKdbxTitle(a); got != "remote" {
+    t.Errorf("title = %q, want the most recent version", got)
+  }
+
+  history := a.child("History").Children
+  titles := make([]string, 0)
+  for _, h := range history {
+    titles = append(titles, testKdbxTitle(h))
+    if h.child("History") != nil {
+      t.Errorf("history version %q has a history of its own", testKdbxTitle(h))
+    }
+  }
+  if len(titles) != 2 || titles[0] != "original" || titles[1] != "local" {
+    t.Errorf("history = %v, want [original local]", titles)
+  }
+
+  if got := testKdbxTitle(entries[testUuidB]); got != "added remotely" {
+    t.Errorf("added entry title = %q", got)
+  }
+}
+
+func TestKdbxMergeUnchanged(t *testing.T) {
+  local := newTestKdbx(t, kdbxCipherChaCha20, nil, testKdbxRoot(testKdbxEntry(testUuidA, "2024-03-01T00:00:00Z", "same", "one")))
+  remote := newTestKdbx(t, kdbxCipherChaCha20, nil, testKdbxRoot(testKdbxEntry(testUuidA, "2024-03-01T00:00:00Z", "same", "one")))
+
+  err := mergeKdbx(local, remote)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if h := testKdbxEntries(local)[testUuidA].child("History"); h != nil && len(h.Children) > 0 {
+    t.Errorf("an unchanged entry got %v history versions", len(h.Children))
+  }
+}
+
+func TestKdbxMergeOtherDatabase(t *testing.T) {
+  local := newTestKdbx(t, kdbxCipherChaCha20, nil, testKdbxRoot())
+  remote := newTestKdbx(t, kdbxCipherChaCha20, nil, testKdbxRoot())
+  remote.root.child("Root").child("Group").child("UUID").Text = "b3RoZXItZ3JvdXAtdXVpZA=="
+
+  if err := mergeKdbx(local, remote); err == nil {
+    t.Error("merged two unrelated databases")
+  }
+}
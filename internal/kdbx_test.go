@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"testing"
+)
+
+func testKdbxNode(name string, text string, children ...*xmlNode) *xmlNode {
+  return &xmlNode{Name: name, Text: text, Children: children}
+}
+
+func testKdbxString(key string, value string, protected bool) *xmlNode {
+  v := testKdbxNode("Value", value)
+  if protected {
+    v.setAttr("Protected", "True")
+  }
+  return testKdbxNode("String", "", testKdbxNode("Key", key), v)
+}
+
+func testKdbxEntry(uuid string, modified string, title string, password string) *xmlNode {
+  return testKdbxNode("Entry", "",
+    testKdbxNode("UUID", uuid),
+    testKdbxString("Title", title, false),
+    testKdbxString("Password", password, true),
+    testKdbxNode("Times", "",
+      testKdbxNode("LastModificationTime", modified),
+      testKdbxNode("LocationChanged", "2024-01-01T00:00:00Z"),
+    ),
+  )
+}
+
+func testKdbxVariantDictionary(values ...any) []byte {
+  var out bytes.Buffer
+  out.Write([]byte{0x00, 0x01})
+  for i := 0; i < len(values); i += 2 {
+    key := values[i].(string)
+    var kind byte
+    var value []byte
+    switch v := values[i + 1].(type) {
+      case uint32:
+        kind, value = 0x04, le32(v)
+      case uint64:
+        kind, value = 0x05, le64(v)
+      case []byte:
+        kind, value = 0x42, v
+    }
+    out.WriteByte(kind)
+    out.Write(le32(uint32(len(key))))
+    out.WriteString(key)
+    out.Write(le32(uint32(len(value))))
+    out.Write(value)
+  }
+  out.WriteByte(0)
+  return out.Bytes()
+}
+
+// newTestKdbx creates a database with a cheap Argon2d KDF around root
+func newTestKdbx(t *testing.T, cipherId []byte, compositeKey []byte, root *xmlNode) *kdbxDatabase {
+  t.Helper()
+
+  random := func(size int) []byte {
+    b := make([]byte, size)
+    rand.Read(b)
+    return b
+  }
+
+  ivSize := 16
+  if bytes.Equal(cipherId, kdbxCipherChaCha20) {
+    ivSize = 12
+  }
+
+  kdf := testKdbxVariantDictionary(
+    "$UUID", kdbxKdfArgon2d,
+    "S", random(32),
+    "P", uint32(1),
+    "M", uint64(64 * 1024),
+    "I", uint64(1),
+    "V", uint32(0x13),
+  )
+  parameters, err := parseVariantDictionary(kdf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  transformedKey, err := kdbxTransformKey(parameters, compositeKey)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return &kdbxDatabase{
+    version: 4 << 16 | 1,
+    header: []kdbxField{
+      {kdbxHeaderCipher, cipherId},
+      {kdbxHeaderCompression, le32(1)},
+      {kdbxHeaderMasterSeed, random(32)},
+      {kdbxHeaderIV, random(ivSize)},
+      {kdbxHeaderKdf, kdf},
+    },
+    root: root,
+    transformedKey: transformedKey,
+  }
+}
+
+func testKdbxRoot(entries ...*xmlNode) *xmlNode {
+  group := testKdbxNode("Group", "",
+    testKdbxNode("UUID", "cm9vdC1ncm91cC11dWlkAA=="),
+    testKdbxNode("Name", "Root"),
+    testKdbxNode("Times", "",
+      testKdbxNode("LastModificationTime", "2024-01-01T00:00:00Z"),
+    ),
+  )
+  group.Children = append(group.Children, entries...)
+
+  return testKdbxNode("KeePassFile", "",
+    testKdbxNode("Meta", "", testKdbxNode("Generator", "syncer")),
+    testKdbxNode("Root", "", group),
+  )
+}
+
+func sameXmlNode(a *xmlNode, b *xmlNode) bool {
+  if a.Name != b.Name || a.Text != b.Text || len(a.Attrs) != len(b.Attrs) || len(a.Children) != len(b.Children) {
+    return false
+  }
+  for i := range a.Attrs {
+    if a.Attrs[i].Name.Local != b.Attrs[i].Name.Local || a.Attrs[i].Value != b.Attrs[i].Value {
+      return false
+    }
+  }
+  for i := range a.Children {
+    if !sameXmlNode(a.Children[i], b.Children[i]) {
+      return false
+    }
+  }
+  return true
+}
+
+func TestKdbxRoundTrip(t *testing.T) {
+  key, err := kdbxCompositeKey("secret", "")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, cipherId := range [][]byte{kdbxCipherChaCha20, kdbxCipherAES, kdbxCipherTwofish} {
+    entry := testKdbxEntry("ZW50cnktMDAwMDAwMDAwMQ==", "2024-02-01T10:00:00Z", "Mail <work> & more", "pässwörd")
+    entry.Children = append(entry.Children, testKdbxNode("Binary", "",
+      testKdbxNode("Key", "notes.txt"),
+      &xmlNode{Name: "Value", Attrs: []xml.Attr{{Name: xml.Name{Local: "Ref"}, Value: "0"}}},
+    ))
+    db := newTestKdbx(t, cipherId, key, testKdbxRoot(entry))
+    db.binaries = []kdbxBinary{{1, []byte("attached notes")}}
+
+    data, err := db.write()
+    if err != nil {
+      t.Fatalf("%x: write: %v", cipherId, err)
+    }
+
+    read, err := readKdbx(data, key)
+    if err != nil {
+      t.Fatalf("%x: read: %v", cipherId, err)
+    }
+    if !sameXmlNode(read.root, db.root) {
+      t.Errorf("%x: XML changed in the round trip", cipherId)
+    }
+    if len(read.binaries) != 1 || read.binaries[0].flags != 1 || string(read.binaries[0].data) != "attached notes" {
+      t.Errorf("%x: binaries = %v", cipherId, read.binaries)
+    }
+    if got := read.root.child("Root").child("Group").child("Entry").child("String").child("Value").Text; got != "Mail <work> & more" {
+      t.Errorf("%x: title = %q", cipherId, got)
+    }
+
+    // Written again, the database must still read with the same key
+    again, err := read.write()
+    if err != nil {
+      t.Fatalf("%x: second write: %v", cipherId, err)
+    }
+    if _, err = readKdbx(again, key); err != nil {
+      t.Errorf("%x: second read: %v", cipherId, err)
+    }
+  }
+}
+
+func TestKdbxWrongKey(t *testing.T) {
+  key, _ := kdbxCompositeKey("secret", "")
+  wrong, _ := kdbxCompositeKey("Secret", "")
+
+  data, err := newTestKdbx(t, kdbxCipherChaCha20, key, testKdbxRoot()).write()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if _, err = readKdbx(data, wrong); err != errKdbxCredentials {
+    t.Errorf("read with the wrong key = %v, want %v", err, errKdbxCredentials)
+  }
+
+  data[len(data) - 40] ^= 1
+  if _, err = readKdbx(data, key); err == nil {
+    t.Error("read of a corrupted database succeeded")
+  }
+}
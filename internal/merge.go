@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+  ErrKeyRequired = errors.New("a password or key file is required to merge")
+  ErrMergeConflict = errors.New("both versions changed the same lines")
+)
+
+type MergeOptions struct {
+  Password string
+  KeyFile string
+}
+
+type MergeInput struct {
+  // Base is the version of the last sync, only for strategies that need it
+  Base []byte
+  Local []byte
+  Remote []byte
+  Options MergeOptions
+}
+
+// MergeStrategy combines the local and remote version of a file that
+// changed on both sides into a single version.
+// When the versions can only be merged partially, the result is returned
+// together with ErrMergeConflict.
+type MergeStrategy interface {
+  Merge(input MergeInput) ([]byte, error)
+  NeedsBase() bool
+}
+
+var mergeStrategies = map[string]MergeStrategy{
+  "kdbx": kdbxMerge{},
+  "text": textMerge{},
+}
+
+func MergeStrategies() []string {
+  names := make([]string, 0)
+  for name := range mergeStrategies {
+    names = append(names, name)
+  }
+  slices.Sort(names)
+  return names
+}
+
+func IsMergeStrategy(name string) bool {
+  _, ok := mergeStrategies[name]
+  return ok || name == ""
+}
+
+// DefaultMergeStrategy picks the merge strategy based on the file type
+func DefaultMergeStrategy(path string) string {
+  switch strings.ToLower(filepath.Ext(path)) {
+    case ".kdbx":
+      return "kdbx"
+    case ".txt", ".md", ".org", ".conf", ".ini", ".toml", ".yaml", ".yml", ".json":
+      return "text"
+    default:
+      return ""
+  }
+}
+
+// Merge resolves the conflict of the file with its merge strategy.
+// The conflict copy is refreshed with the latest remote version first, the
+// merged result replaces the local file and is pushed to the remote.
+func Merge(repo Repository, file *File, options MergeOptions) error {
+  if file.Status != Conflict {
+    return fmt.Errorf("%v has no conflict", file.RemoteName)
+  }
+
+  strategy, ok := mergeStrategies[file.Merge]
+  if !ok {
+    return fmt.Errorf("%v has no merge strategy", file.RemoteName)
+  }
+
+  if file.ConflictPath == "" {
+    file.ConflictPath = conflictPath(file.LocalPath, time.Now())
+  }
+  _, err := repo.Download(file, file.ConflictPath)
+  if err != nil {
+    return err
+  }
+
+  return mergeConflict(repo, file, strategy, options)
+}
+
+// mergeConflict merges the local file with its conflict copy.
+// A merged result replaces the local file and is pushed, a partially merged
+// result with conflict markers replaces the local file and the file stays
+// in conflict.
+func mergeConflict(repo Repository, file *File, strategy MergeStrategy, options MergeOptions) error {
+  local, err := os.ReadFile(file.LocalPath)
+  if err != nil {
+    return err
+  }
+
+  remote, err := os.ReadFile(file.ConflictPath)
+  if err != nil {
+    return err
+  }
+
+  var base []byte
+  if strategy.NeedsBase() {
+    base, err = os.ReadFile(basePath(*file))
+    if err != nil {
+      return fmt.Errorf("no copy of the last synced version of %v to merge with\n%w", file.RemoteName, err)
+    }
+  }
+
+  merged, err := strategy.Merge(MergeInput{
+    Base: base,
+    Local: local,
+    Remote: remote,
+    Options: options,
+  })
+  if errors.Is(err, ErrMergeConflict) {
+    if !bytes.Equal(merged, local) {
+      err := snapshot(*file)
+      if err != nil {
+        return err
+      }
+
+      err = writeFileAtomic(file.LocalPath, merged)
+      if err != nil {
+        return err
+      }
+    }
+    return fmt.Errorf("%w: %v, the conflicting lines are marked in %v", ErrMergeConflict, file.RemoteName, file.LocalPath)
+  }
+  if err != nil {
+    return err
+  }
+
+  err = snapshot(*file)
+  if err != nil {
+    return err
+  }
+
+  err = writeFileAtomic(file.LocalPath, merged)
+  if err != nil {
+    return err
+  }
+
+  return Resolve(repo, file, KeepLocal)
+}
+
+// basePath is where the version of the last sync is kept for merging
+func basePath(file File) string {
+  return filepath.Join(configDir, "base", strconv.Itoa(file.Id))
+}
+
+// saveBase keeps a copy of the local file as the last synced version, for
+// files with a merge strategy that needs it. The copy is only kept when it
+// has the hash of the remote version, the local file can have changed since
+// it was downloaded or uploaded, the previous base is kept then.
+func saveBase(file File) error {
+  strategy, ok := mergeStrategies[file.Merge]
+  if !ok || !strategy.NeedsBase() {
+    return nil
+  }
+
+  data, err := os.ReadFile(file.LocalPath)
+  if err != nil {
+    return err
+  }
+  sum := md5.Sum(data)
+  if hex.EncodeToString(sum[:]) != file.Hash {
+    return nil
+  }
+
+  err = os.MkdirAll(filepath.Dir(basePath(file)), 0700)
+  if err != nil {
+    return err
+  }
+
+  return writeFileAtomic(basePath(file), data)
+}
+
+func removeBase(file File) error {
+  err := os.Remove(basePath(file))
+  if err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveBase(t *testing.T) {
+  initTestConfig(t)
+
+  path := filepath.Join(t.TempDir(), "notes.txt")
+  err := os.WriteFile(path, []byte("synced"), 0600)
+  if err != nil {
+    t.Fatal(err)
+  }
+  hash, err := HashFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  file := File{Id: 1, LocalPath: path, Merge: "text", Hash: hash}
+
+  tests := []struct {
+    name string
+    local string
+    base string
+  }{
+    {"local matches the remote", "synced", "synced"},
+    {"local changed after the sync", "edited", "synced"},
+  }
+
+  for _, test := range tests {
+    err = os.WriteFile(path, []byte(test.local), 0600)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    err = saveBase(file)
+    if err != nil {
+      t.Errorf("%v: %v", test.name, err)
+      continue
+    }
+
+    base, err := os.ReadFile(basePath(file))
+    if err != nil {
+      t.Errorf("%v: %v", test.name, err)
+      continue
+    }
+    if string(base) != test.base {
+      t.Errorf("%v: base = %q, want %q", test.name, base, test.base)
+    }
+  }
+}
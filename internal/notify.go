@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Channel is a registration for push notifications about remote changes
+type Channel struct {
+  Id string
+  ResourceId string
+  Token string
+  Expiration time.Time
+}
+
+// NewChannel returns a channel with a new id and token, to be added to the
+// Receiver and then registered with Repository.Watch
+func NewChannel() Channel {
+  return Channel{
+    Id: randomToken(16),
+    Token: randomToken(32),
+  }
+}
+
+// Receiver is the webhook the push notifications of the registered channels
+// are sent to. Every valid notification is reported on Notifications with
+// the id of its channel, notifications that arrive while one is pending are
+// dropped.
+type Receiver struct {
+  Notifications chan string
+  mutex sync.Mutex
+  channels map[string]Channel
+}
+
+func NewReceiver() *Receiver {
+  return &Receiver{
+    Notifications: make(chan string, 1),
+    channels: make(map[string]Channel),
+  }
+}
+
+// Add accepts the notifications of the channel
+func (r *Receiver) Add(channel Channel) {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+
+  r.channels[channel.Id] = channel
+}
+
+// Remove stops accepting the notifications of the channel
+func (r *Receiver) Remove(channel Channel) {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+
+  delete(r.channels, channel.Id)
+}
+
+// ServeHTTP validates the channel headers of a notification, notifications
+// of unknown channels or with a wrong token are rejected
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+  if req.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+
+  id := req.Header.Get("X-Goog-Channel-ID")
+  token := req.Header.Get("X-Goog-Channel-Token")
+
+  r.mutex.Lock()
+  channel, ok := r.channels[id]
+  r.mutex.Unlock()
+
+  if !ok || subtle.ConstantTimeCompare([]byte(channel.Token), []byte(token)) != 1 {
+    http.Error(w, "unknown channel", http.StatusForbidden)
+    return
+  }
+  if resource := req.Header.Get("X-Goog-Resource-ID"); channel.ResourceId != "" && resource != "" && resource != channel.ResourceId {
+    http.Error(w, "unknown resource", http.StatusForbidden)
+    return
+  }
+
+  w.WriteHeader(http.StatusOK)
+
+  // The first message of a channel only confirms that it works
+  if req.Header.Get("X-Goog-Resource-State") == "sync" {
+    return
+  }
+
+  select {
+  case r.Notifications <- id:
+  default:
+  }
+}
+
+// randomToken returns n random bytes hex encoded
+func randomToken(n int) string {
+  b := make([]byte, n)
+  rand.Read(b)
+
+  return hex.EncodeToString(b)
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReceiver(t *testing.T) {
+  receiver := NewReceiver()
+  receiver.Add(Channel{Id: "channel", ResourceId: "resource", Token: "token"})
+  server := httptest.NewServer(receiver)
+  defer server.Close()
+
+  post := func(method string, headers map[string]string) int {
+    req, err := http.NewRequest(method, server.URL, nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for k, v := range headers {
+      req.Header.Set(k, v)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+      t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp.StatusCode
+  }
+
+  tests := []struct {
+    name string
+    method string
+    headers map[string]string
+    status int
+    notified bool
+  }{
+    {
+      name: "change",
+      method: http.MethodPost,
+      headers: map[string]string{"X-Goog-Channel-ID": "channel", "X-Goog-Channel-Token": "token", "X-Goog-Resource-ID": "resource", "X-Goog-Resource-State": "change"},
+      status: http.StatusOK,
+      notified: true,
+    },
+    {
+      name: "sync message",
+      method: http.MethodPost,
+      headers: map[string]string{"X-Goog-Channel-ID": "channel", "X-Goog-Channel-Token": "token", "X-Goog-Resource-State": "sync"},
+      status: http.StatusOK,
+    },
+    {
+      name: "unknown channel",
+      method: http.MethodPost,
+      headers: map[string]string{"X-Goog-Channel-ID": "other", "X-Goog-Channel-Token": "token"},
+      status: http.StatusForbidden,
+    },
+    {
+      name: "wrong token",
+      method: http.MethodPost,
+      headers: map[string]string{"X-Goog-Channel-ID": "channel", "X-Goog-Channel-Token": "guess"},
+      status: http.StatusForbidden,
+    },
+    {
+      name: "missing token",
+      method: http.MethodPost,
+      headers: map[string]string{"X-Goog-Channel-ID": "channel"},
+      status: http.StatusForbidden,
+    },
+    {
+      name: "other resource",
+      method: http.MethodPost,
+      headers: map[string]string{"X-Goog-Channel-ID": "channel", "X-Goog-Channel-Token": "token", "X-Goog-Resource-ID": "other"},
+      status: http.StatusForbidden,
+    },
+    {
+      name: "get",
+      method: http.MethodGet,
+      headers: map[string]string{"X-Goog-Channel-ID": "channel", "X-Goog-Channel-Token": "token"},
+      status: http.StatusMethodNotAllowed,
+    },
+  }
+
+  for _, test := range tests {
+    if status := post(test.method, test.headers); status != test.status {
+      t.Errorf("%v: status = %v, want %v", test.name, status, test.status)
+    }
+
+    select {
+    case id := <-receiver.Notifications:
+      if !test.notified {
+        t.Errorf("%v: notified", test.name)
+      } else if id != "channel" {
+        t.Errorf("%v: notified for %q, want %q", test.name, id, "channel")
+      }
+    default:
+      if test.notified {
+        t.Errorf("%v: not notified", test.name)
+      }
+    }
+  }
+
+  // A removed channel is unknown
+  receiver.Remove(Channel{Id: "channel"})
+  if status := post(http.MethodPost, map[string]string{"X-Goog-Channel-ID": "channel", "X-Goog-Channel-Token": "token"}); status != http.StatusForbidden {
+    t.Errorf("removed channel: status = %v, want %v", status, http.StatusForbidden)
+  }
+}
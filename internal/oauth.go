@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+  authTimeout = time.Minute * 5
+)
+
+var ErrAccessDenied = errors.New("access was denied in the browser")
+
+// openBrowser opens the authorization page, tests replace it
+var openBrowser = func(url string) {
+  exec.Command("xdg-open", url).Start()
+}
+
+// getTokenFromWeb runs the authorization code flow for installed apps.
+// The browser redirects back to a server on a random loopback port, the
+// request is matched by a random state and the code is bound to this
+// process with PKCE.
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    return nil, err
+  }
+  defer listener.Close()
+
+  config.RedirectURL = fmt.Sprintf("http://%v/", listener.Addr())
+
+  state := randomToken(16)
+  verifier := oauth2.GenerateVerifier()
+  authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier))
+
+  fmt.Printf("Opening the browser for the authorization, if it does not open visit\n%v\n", authURL)
+  openBrowser(authURL)
+
+  code, err := getAuthorizationcodeFromRedirect(listener, state, authTimeout)
+  if err != nil {
+    return nil, err
+  }
+
+  tok, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+  if err != nil {
+    return nil, err
+  }
+
+  return tok, nil
+}
+
+// getTokenFromDevice runs the device authorization grant, for machines
+// without a browser. The user enters the printed code on any other device,
+// meanwhile the token endpoint is polled at the interval the provider asks
+// for, slowing down when it tells to.
+func getTokenFromDevice(ctx context.Context, config *oauth2.Config, out io.Writer) (*oauth2.Token, error) {
+  auth, err := config.DeviceAuth(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  uri := auth.VerificationURI
+  if uri == "" {
+    uri = auth.VerificationURIComplete
+  }
+  fmt.Fprintf(out, "Visit %v on any device and enter the code %v\n", uri, auth.UserCode)
+
+  tok, err := config.DeviceAccessToken(ctx, auth)
+  var r *oauth2.RetrieveError
+  if errors.As(err, &r) && r.ErrorCode == "access_denied" {
+    return nil, ErrAccessDenied
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  return tok, nil
+}
+
+type authResult struct {
+  code string
+  err error
+}
+
+// getAuthorizationcodeFromRedirect serves the redirect of the browser until
+// a request with the right state arrives or the timeout passes.
+// Other requests, like the favicon, are answered without ending the flow.
+func getAuthorizationcodeFromRedirect(listener net.Listener, state string, timeout time.Duration) (string, error) {
+  results := make(chan authResult, 1)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    if r.URL.Path != "/" {
+      http.NotFound(w, r)
+      return
+    }
+    if query.Get("state") != state {
+      http.Error(w, "The authorization request is unknown", http.StatusBadRequest)
+      return
+    }
+
+    var result authResult
+    switch e := query.Get("error"); {
+      case e == "access_denied":
+        result.err = ErrAccessDenied
+      case e != "":
+        result.err = fmt.Errorf("authorization failed: %v", e)
+      case query.Get("code") == "":
+        result.err = errors.New("authorization failed: no code was returned")
+      default:
+        result.code = query.Get("code")
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    if result.err != nil {
+      w.WriteHeader(http.StatusForbidden)
+      writeAuthPage(w, "Authorization failed", result.err.Error())
+    } else {
+      writeAuthPage(w, "Syncer is authorized", "You can close this browser window and return to the terminal.")
+    }
+
+    select {
+    case results <- result:
+    default:
+    }
+  })
+
+  server := &http.Server{
+    Handler: mux,
+    ReadHeaderTimeout: time.Second * 10,
+  }
+  go server.Serve(listener)
+  defer func() {
+    // Let the page of the last request be sent
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    server.Shutdown(ctx)
+  }()
+
+  select {
+  case result := <-results:
+    return result.code, result.err
+  case <-time.After(timeout):
+    return "", fmt.Errorf("no authorization within %v", timeout)
+  }
+}
+
+func writeAuthPage(w http.ResponseWriter, title string, message string) {
+  fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]v</title>
+<style>
+body { font-family: sans-serif; display: flex; justify-content: center; margin-top: 15vh; color: #333; }
+main { max-width: 30em; text-align: center; }
+</style>
+</head>
+<body>
+<main>
+<h1>%[1]v</h1>
+<p>%[2]v</p>
+</main>
+</body>
+</html>
+`, html.EscapeString(title), html.EscapeString(message))
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newDeviceServer is a fake provider with a device authorization endpoint
+// and a token endpoint that answers with the given errors before it returns
+// a token
+func newDeviceServer(t *testing.T, responses ...string) (*httptest.Server, *oauth2.Config) {
+  var mu sync.Mutex
+  mux := http.NewServeMux()
+
+  mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]any{
+      "device_code": "device-code",
+      "user_code": "ABCD-EFGH",
+      "verification_uri": "https://example.com/device",
+      "expires_in": 60,
+      "interval": 1,
+    })
+  })
+
+  mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+    if r.FormValue("device_code") != "device-code" || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+      t.Errorf("token request = %v", r.Form)
+    }
+
+    mu.Lock()
+    var response string
+    if len(responses) > 0 {
+      response, responses = responses[0], responses[1:]
+    }
+    mu.Unlock()
+
+    w.Header().Set("Content-Type", "application/json")
+    if response != "" {
+      w.WriteHeader(http.StatusBadRequest)
+      json.NewEncoder(w).Encode(map[string]string{"error": response})
+      return
+    }
+    json.NewEncoder(w).Encode(map[string]any{
+      "access_token": "access",
+      "refresh_token": "refresh",
+      "token_type": "Bearer",
+      "expires_in": 3600,
+    })
+  })
+
+  server := httptest.NewServer(mux)
+  config := &oauth2.Config{
+    ClientID: "client",
+    Endpoint: oauth2.Endpoint{
+      DeviceAuthURL: server.URL + "/device",
+      TokenURL: server.URL + "/token",
+      AuthStyle: oauth2.AuthStyleInParams,
+    },
+  }
+
+  return server, config
+}
+
+func TestGetTokenFromDevice(t *testing.T) {
+  tests := []struct {
+    name string
+    responses []string
+    slow bool
+    err error
+    code string
+  }{
+    {name: "authorized", responses: nil},
+    {name: "authorization pending", responses: []string{"authorization_pending", "authorization_pending"}},
+    {name: "slow down", responses: []string{"slow_down"}, slow: true},
+    {name: "access denied", responses: []string{"authorization_pending", "access_denied"}, err: ErrAccessDenied},
+    {name: "expired token", responses: []string{"expired_token"}, code: "expired_token"},
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if test.slow && testing.Short() {
+        t.Skip("slow_down makes the next poll 5 seconds later")
+      }
+      t.Parallel()
+
+      server, config := newDeviceServer(t, test.responses...)
+      defer server.Close()
+
+      var out bytes.Buffer
+      tok, err := getTokenFromDevice(context.Background(), config, &out)
+
+      if !strings.Contains(out.String(), "https://example.com/device") || !strings.Contains(out.String(), "ABCD-EFGH") {
+        t.Errorf("printed %q, want the verification URI and the user code", out.String())
+      }
+
+      switch {
+        case test.err != nil:
+          if !errors.Is(err, test.err) {
+            t.Errorf("error = %v, want %v", err, test.err)
+          }
+        case test.code != "":
+          var r *oauth2.RetrieveError
+          if !errors.As(err, &r) || r.ErrorCode != test.code {
+            t.Errorf("error = %v, want %v", err, test.code)
+          }
+        case err != nil:
+          t.Errorf("error = %v", err)
+        case tok.AccessToken != "access" || tok.RefreshToken != "refresh":
+          t.Errorf("token = %+v", tok)
+      }
+    })
+  }
+}
+
+func TestAuthorizationRedirect(t *testing.T) {
+  tests := []struct {
+    name string
+    // queries are sent one after another, the last one ends the flow
+    queries []string
+    statuses []int
+    code string
+    err error
+  }{
+    {name: "code", queries: []string{"state=state&code=code"}, statuses: []int{http.StatusOK}, code: "code"},
+    {name: "unknown state", queries: []string{"state=other&code=forged", "code=forged", "state=state&code=code"}, statuses: []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusOK}, code: "code"},
+    {name: "access denied", queries: []string{"state=state&error=access_denied"}, statuses: []int{http.StatusForbidden}, err: ErrAccessDenied},
+    {name: "other error", queries: []string{"state=state&error=server_error"}, statuses: []int{http.StatusForbidden}},
+    {name: "no code", queries: []string{"state=state"}, statuses: []int{http.StatusForbidden}},
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      listener, err := net.Listen("tcp", "127.0.0.1:0")
+      if err != nil {
+        t.Fatal(err)
+      }
+      defer listener.Close()
+
+      type result struct {
+        code string
+        err error
+      }
+      results := make(chan result, 1)
+      go func() {
+        code, err := getAuthorizationcodeFromRedirect(listener, "state", 5 * time.Second)
+        results <- result{code, err}
+      }()
+
+      for i, query := range test.queries {
+        resp, err := http.Get(fmt.Sprintf("http://%v/?%v", listener.Addr(), query))
+        if err != nil {
+          t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != test.statuses[i] {
+          t.Errorf("%q: status = %v, want %v", query, resp.StatusCode, test.statuses[i])
+        }
+      }
+
+      r := <-results
+      switch {
+        case test.code != "":
+          if r.err != nil || r.code != test.code {
+            t.Errorf("code = %q, %v, want %q", r.code, r.err, test.code)
+          }
+        case test.err != nil:
+          if !errors.Is(r.err, test.err) {
+            t.Errorf("error = %v, want %v", r.err, test.err)
+          }
+        case r.err == nil:
+          t.Errorf("code = %q, want an error", r.code)
+      }
+    })
+  }
+}
+
+func TestAuthorizationRedirectTimeout(t *testing.T) {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer listener.Close()
+
+  if _, err = getAuthorizationcodeFromRedirect(listener, "state", 10 * time.Millisecond); err == nil {
+    t.Error("no error without a redirect")
+  }
+}
+
+func TestGetTokenFromWeb(t *testing.T) {
+  var challenge string
+  var redirect string
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+    // The verifier has to match the challenge of the authorization request
+    sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+    if r.FormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge || r.FormValue("redirect_uri") != redirect {
+      w.WriteHeader(http.StatusBadRequest)
+      json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+      return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]any{
+      "access_token": "access",
+      "refresh_token": "refresh",
+      "token_type": "Bearer",
+      "expires_in": 3600,
+    })
+  })
+  server := httptest.NewServer(mux)
+  defer server.Close()
+
+  config := &oauth2.Config{
+    ClientID: "client",
+    Endpoint: oauth2.Endpoint{
+      AuthURL: server.URL + "/auth",
+      TokenURL: server.URL + "/token",
+      AuthStyle: oauth2.AuthStyleInParams,
+    },
+  }
+
+  defer func(open func(string)) { openBrowser = open }(openBrowser)
+  openBrowser = func(authURL string) {
+    u, err := url.Parse(authURL)
+    if err != nil {
+      t.Error(err)
+      return
+    }
+    query := u.Query()
+    if query.Get("code_challenge_method") != "S256" || query.Get("state") == "" {
+      t.Errorf("authorization URL = %v, want PKCE and a state", authURL)
+    }
+    challenge = query.Get("code_challenge")
+    redirect = query.Get("redirect_uri")
+
+    // The browser comes back with the state of the request
+    go func() {
+      resp, err := http.Get(redirect + "?" + url.Values{"state": {query.Get("state")}, "code": {"code"}}.Encode())
+      if err != nil {
+        t.Error(err)
+        return
+      }
+      resp.Body.Close()
+    }()
+  }
+
+  tok, err := getTokenFromWeb(context.Background(), config)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+    t.Errorf("token = %+v", tok)
+  }
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package internal
+
+// PidFile is only implemented on unix, other platforms don't keep a second
+// daemon from starting
+type PidFile struct{}
+
+func PidFilePath() string {
+  return ""
+}
+
+func LockPidFile() (*PidFile, error) {
+  return &PidFile{}, nil
+}
+
+func (p *PidFile) Close() error {
+  return nil
+}
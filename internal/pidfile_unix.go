@@ -0,0 +1,77 @@
+//go:build unix
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// PidFile holds the lock that keeps a second daemon from starting
+type PidFile struct {
+  file *os.File
+}
+
+// PidFilePath returns the path of the pid file of the daemon
+func PidFilePath() string {
+  return filepath.Join(runtimeDir(), "syncerd.pid")
+}
+
+// LockPidFile writes the pid of the process to the pid file and locks it
+// for as long as the process runs. It fails when another daemon holds the
+// lock.
+func LockPidFile() (*PidFile, error) {
+  dir, err := makeRuntimeDir()
+  if err != nil {
+    return nil, err
+  }
+  path := filepath.Join(dir, "syncerd.pid")
+
+  f, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0600)
+  if err != nil {
+    return nil, err
+  }
+
+  err = unix.Flock(int(f.Fd()), unix.LOCK_EX | unix.LOCK_NB)
+  if errors.Is(err, unix.EWOULDBLOCK) {
+    content, _ := os.ReadFile(path)
+    f.Close()
+    return nil, fmt.Errorf("another daemon is running with the pid %v", strings.TrimSpace(string(content)))
+  }
+  if err != nil {
+    f.Close()
+    return nil, err
+  }
+
+  err = f.Truncate(0)
+  if err == nil {
+    _, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+  }
+  if err == nil {
+    err = f.Sync()
+  }
+  if err != nil {
+    f.Close()
+    return nil, err
+  }
+
+  return &PidFile{file: f}, nil
+}
+
+// Close removes the pid file and releases the lock
+func (p *PidFile) Close() error {
+  err := os.Remove(p.file.Name())
+  if err != nil && !os.IsNotExist(err) {
+    p.file.Close()
+    return err
+  }
+
+  // Closing the file releases the lock
+  return p.file.Close()
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package internal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockPidFile(t *testing.T) {
+  t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+  pid := strconv.Itoa(os.Getpid())
+
+  // A pid file left behind by a daemon that didn't shut down isn't locked
+  err := os.MkdirAll(runtimeDir(), 0700)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = os.WriteFile(PidFilePath(), []byte("123456789\n"), 0600)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  p, err := LockPidFile()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  data, err := os.ReadFile(PidFilePath())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if string(data) != pid + "\n" {
+    t.Errorf("pid file = %q, want %q", data, pid + "\n")
+  }
+
+  // The lock is held for the open file, so a second one fails in the same
+  // process as well
+  if _, err = LockPidFile(); err == nil || !strings.Contains(err.Error(), pid) {
+    t.Errorf("second lock = %v, want an error with the pid %v", err, pid)
+  }
+
+  err = p.Close()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err = os.Stat(PidFilePath()); !os.IsNotExist(err) {
+    t.Errorf("the pid file is left after closing: %v", err)
+  }
+
+  p, err = LockPidFile()
+  if err != nil {
+    t.Fatalf("lock after closing: %v", err)
+  }
+  p.Close()
+}
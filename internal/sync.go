@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrChangesUnknown is returned by Repository.Changes when the changes since
+// the last call can't be told, so every file needs to be checked
+var ErrChangesUnknown = errors.New("remote changes are unknown")
+
+// ErrPaused is returned for files that are not synced after a logout
+var ErrPaused = errors.New("syncing is paused, run \"syncer auth\" to resume")
+
+// ErrRemoteChanged is returned by Push when the remote file changed, pushing
+// would overwrite the change so it has to be pulled first
+var ErrRemoteChanged = errors.New("the remote file changed, pull it first")
+
+type Change int
+const (
+  Unchanged Change = iota
+  LocalChanged
+  RemoteChanged
+  BothChanged
+)
+var changeString = map[Change]string{
+  Unchanged: "Unchanged",
+  LocalChanged: "Local changed",
+  RemoteChanged: "Remote changed",
+  BothChanged: "Both changed",
+}
+func (change Change) String() string {
+  return changeString[change]
+}
+
+// Remote is the state of the remote version of a file
+type Remote struct {
+  Hash string
+  Revision string
+  Size int64
+  Modified time.Time
+}
+
+// Plan is what needs to happen to bring a file in sync
+type Plan struct {
+  Change Change
+  Local string
+  Remote Remote
+}
+
+// PlanFile compares the local and remote version of the file with the
+// version that was synced the last time
+func PlanFile(repo Repository, file *File) (Plan, error) {
+  remote, err := repo.Stat(file)
+  if err != nil {
+    return Plan{}, err
+  }
+
+  local, err := HashFile(file.LocalPath)
+  if err != nil {
+    return Plan{}, err
+  }
+
+  return Plan{
+    Change: Classify(*file, local, remote),
+    Local: local,
+    Remote: remote,
+  }, nil
+}
+
+// Classify decides which side changed since the last sync, based on the
+// stored content hash. A local file that does not exist is restored from
+// the remote.
+func Classify(file File, local string, remote Remote) Change {
+  if local == "" {
+    return RemoteChanged
+  }
+  if local == remote.Hash {
+    return Unchanged
+  }
+
+  localChanged := local != file.Hash
+
+  var remoteChanged bool
+  if remote.Hash != "" {
+    remoteChanged = remote.Hash != file.Hash
+  } else {
+    remoteChanged = remote.Revision != file.Revision
+  }
+
+  switch {
+    case localChanged && remoteChanged:
+      return BothChanged
+    case localChanged:
+      return LocalChanged
+    case remoteChanged:
+      return RemoteChanged
+    default:
+      return Unchanged
+  }
+}
+
+// markSynced stores the file as being in sync with the remote
+func markSynced(file *File) error {
+  file.Status = Synced
+  file.ConflictPath = ""
+
+  err := saveBase(*file)
+  if err != nil {
+    return err
+  }
+
+  err = UpdateFile(*file)
+  if err != nil {
+    return err
+  }
+
+  return setPullNeeded(file, false)
+}
+
+// Outcome is what pulling or pushing a file did
+type Outcome string
+const (
+  Pulled Outcome = "pulled"
+  Pushed Outcome = "pushed"
+  UpToDate Outcome = "up to date"
+  // PushFirst is a pull that found only local changes
+  PushFirst Outcome = "local changed, push first"
+  // PullFirst is a push that was refused because the remote changed
+  PullFirst Outcome = "remote changed, pull first"
+  Updated Outcome = "updated"
+  InConflict Outcome = "conflict"
+  Skipped Outcome = "skipped"
+  Failed Outcome = "failed"
+)
+
+// Failure tells if the file was not synced
+func (outcome Outcome) Failure() bool {
+  return outcome == Failed || outcome == PullFirst
+}
+
+// SyncOptions chooses what SyncFile does
+type SyncOptions struct {
+  Push bool
+  // Force overwrites the other side without checking which side changed
+  Force bool
+}
+
+// SyncFile pulls or pushes the file and tells what it did
+func SyncFile(repo Repository, file *File, options SyncOptions) (Outcome, error) {
+  before := *file
+
+  var err error
+  switch {
+    case options.Push && options.Force:
+      err = ForcePush(repo, file)
+    case options.Push:
+      err = repo.Push(file)
+    case options.Force:
+      err = ForcePull(repo, file)
+    default:
+      err = repo.Pull(file)
+  }
+  if errors.Is(err, ErrRemoteChanged) {
+    return PullFirst, nil
+  }
+  if err != nil {
+    return Failed, err
+  }
+
+  switch {
+    case file.Status == Conflict:
+      return InConflict, nil
+    case !options.Push && file.Status != Synced:
+      return PushFirst, nil
+    case before.Revision == file.Revision && before.Hash == file.Hash:
+      return UpToDate, nil
+    case options.Push && before.Revision != file.Revision:
+      return Pushed, nil
+    case !options.Push && before.Hash != file.Hash:
+      return Pulled, nil
+    default:
+      return Updated, nil
+  }
+}
+
+// ForcePull overwrites the local file with the remote version, without
+// checking which side changed. The local version is kept in the history.
+func ForcePull(repo Repository, file *File) error {
+  err := checkForce(file)
+  if err != nil {
+    return err
+  }
+
+  err = snapshot(*file)
+  if err != nil {
+    return err
+  }
+
+  remote, err := repo.Download(file, file.LocalPath)
+  if err != nil {
+    return pullFailed(file, file.Status, err)
+  }
+
+  file.Hash = remote.Hash
+  file.Revision = remote.Revision
+
+  return markSynced(file)
+}
+
+// ForcePush overwrites the remote file with the local version, without
+// checking which side changed
+func ForcePush(repo Repository, file *File) error {
+  err := checkForce(file)
+  if err != nil {
+    return err
+  }
+
+  remote, err := repo.Upload(file)
+  if err != nil {
+    return syncFailed(file, file.Status, err)
+  }
+
+  file.Hash = remote.Hash
+  file.Revision = remote.Revision
+
+  return markSynced(file)
+}
+
+// checkForce refuses to overwrite paused files and files in conflict, a
+// conflict is resolved with Resolve instead
+func checkForce(file *File) error {
+  switch file.Status {
+    case Paused:
+      return fmt.Errorf("%v: %w", file.RemoteName, ErrPaused)
+    case Conflict:
+      return unresolvedConflict(file)
+  }
+  return nil
+}
+
+// syncFailed marks the file when it failed because the credentials have to
+// be renewed. A sync that was canceled gets the status from before it
+// started back, any other failure leaves the status at Error.
+func syncFailed(file *File, previous Status, err error) error {
+  switch {
+    case errors.Is(err, ErrNeedsReauth):
+      file.Status = NeedsReauth
+      UpdateFile(*file)
+    case errors.Is(err, context.Canceled):
+      file.Status = previous
+      UpdateFile(*file)
+  }
+
+  return err
+}
+
+// pullFailed is syncFailed for a pull, a pull that was canceled keeps the
+// file marked as needing one
+func pullFailed(file *File, previous Status, err error) error {
+  if errors.Is(err, context.Canceled) {
+    setPullNeeded(file, true)
+  }
+
+  return syncFailed(file, previous, err)
+}
+
+// HashFile returns the hex encoded MD5 of the file, which is the same
+// checksum Google Drive keeps, or an empty string when the file does not exist
+func HashFile(path string) (string, error) {
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return "", nil
+  }
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+
+  h := md5.New()
+  _, err = io.Copy(h, f)
+  if err != nil {
+    return "", err
+  }
+
+  return hex.EncodeToString(h.Sum(nil)), nil
+}
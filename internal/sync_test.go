@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// statRepository is a repository that only knows the remote state
+type statRepository struct {
+  Repository
+  remote Remote
+  push error
+  download error
+}
+
+func (repo *statRepository) Stat(file *File) (Remote, error) {
+  return repo.remote, nil
+}
+
+func (repo *statRepository) Push(file *File) error {
+  return repo.push
+}
+
+func (repo *statRepository) Download(file *File, path string) (Remote, error) {
+  return repo.remote, repo.download
+}
+
+func TestClassify(t *testing.T) {
+  synced := File{Hash: "base", Revision: "1"}
+
+  tests := []struct {
+    name string
+    file File
+    local string
+    remote Remote
+    change Change
+  }{
+    {"unchanged", synced, "base", Remote{Hash: "base", Revision: "1"}, Unchanged},
+    {"local changed", synced, "local", Remote{Hash: "base", Revision: "1"}, LocalChanged},
+    {"remote changed", synced, "base", Remote{Hash: "remote", Revision: "2"}, RemoteChanged},
+    {"both changed", synced, "local", Remote{Hash: "remote", Revision: "2"}, BothChanged},
+    {"same change on both sides", synced, "same", Remote{Hash: "same", Revision: "2"}, Unchanged},
+    {"local file missing", synced, "", Remote{Hash: "base", Revision: "1"}, RemoteChanged},
+    {"never synced", File{}, "local", Remote{Hash: "remote", Revision: "1"}, BothChanged},
+    {"remote revision changed without hash", synced, "base", Remote{Revision: "2"}, RemoteChanged},
+    {"remote revision unchanged without hash", synced, "local", Remote{Revision: "1"}, LocalChanged},
+  }
+
+  for _, test := range tests {
+    if change := Classify(test.file, test.local, test.remote); change != test.change {
+      t.Errorf("%v: change = %v, want %v", test.name, change, test.change)
+    }
+  }
+}
+
+func TestPlanFile(t *testing.T) {
+  dir := t.TempDir()
+  path := filepath.Join(dir, "file")
+  err := os.WriteFile(path, []byte("content"), 0600)
+  if err != nil {
+    t.Fatal(err)
+  }
+  local, err := HashFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    name string
+    path string
+    hash string
+    remote string
+    change Change
+  }{
+    {"unchanged", path, local, local, Unchanged},
+    {"local changed", path, "base", "base", LocalChanged},
+    {"remote changed", path, local, "remote", RemoteChanged},
+    {"both changed", path, "base", "remote", BothChanged},
+    {"local file missing", filepath.Join(dir, "missing"), "base", "base", RemoteChanged},
+  }
+
+  for _, test := range tests {
+    file := &File{LocalPath: test.path, Hash: test.hash, Revision: "1"}
+    repo := &statRepository{remote: Remote{Hash: test.remote, Revision: "2"}}
+
+    plan, err := PlanFile(repo, file)
+    if err != nil {
+      t.Errorf("%v: %v", test.name, err)
+      continue
+    }
+    if plan.Change != test.change {
+      t.Errorf("%v: change = %v, want %v", test.name, plan.Change, test.change)
+    }
+    if plan.Remote != repo.remote {
+      t.Errorf("%v: remote = %+v, want %+v", test.name, plan.Remote, repo.remote)
+    }
+  }
+}
+
+func TestSyncFileRemoteChanged(t *testing.T) {
+  file := &File{RemoteName: "file", Status: Synced}
+  repo := &statRepository{push: fmt.Errorf("%v: %w", file.RemoteName, ErrRemoteChanged)}
+
+  outcome, err := SyncFile(repo, file, SyncOptions{Push: true})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if outcome != PullFirst || !outcome.Failure() {
+    t.Errorf("outcome = %v, want %v as a failure", outcome, PullFirst)
+  }
+}
+
+func TestForcePullCanceled(t *testing.T) {
+  initTestConfig(t)
+  file := addTestFiles(t, "a")[0]
+  file.Status = Synced
+  err := UpdateFile(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  repo := &statRepository{download: fmt.Errorf("download: %w", context.Canceled)}
+  err = ForcePull(repo, &file)
+  if !errors.Is(err, context.Canceled) {
+    t.Fatalf("error = %v, want %v", err, context.Canceled)
+  }
+
+  files, err := GetFiles()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if files[0].Status != Synced || !files[0].PullNeeded {
+    t.Errorf("status = %v, pull needed = %v, want %v and a pull needed", files[0].Status, files[0].PullNeeded, Synced)
+  }
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notify tells systemd about the state of the daemon when it runs as a
+// service of Type=notify, like "READY=1" or "STATUS=Syncing". It does
+// nothing when the daemon isn't started by systemd.
+func Notify(state ...string) error {
+  socket := os.Getenv("NOTIFY_SOCKET")
+  if socket == "" {
+    return nil
+  }
+  // A socket in the abstract namespace starts with @
+  if strings.HasPrefix(socket, "@") {
+    socket = "\x00" + socket[1:]
+  }
+
+  conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+
+  _, err = conn.Write([]byte(strings.Join(state, "\n")))
+  return err
+}
+
+// WatchdogInterval returns how often systemd expects "WATCHDOG=1" from the
+// daemon, or 0 when the service has no watchdog. The pings are sent twice
+// as often, as systemd recommends.
+func WatchdogInterval() time.Duration {
+  pid := os.Getenv("WATCHDOG_PID")
+  if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+    return 0
+  }
+
+  usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+  if err != nil || usec <= 0 {
+    return 0
+  }
+
+  return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// textMerge is a line based three-way merge like diff3, changes of both
+// versions are combined as long as they don't touch the same lines
+type textMerge struct{}
+
+func (textMerge) NeedsBase() bool {
+  return true
+}
+
+func (textMerge) Merge(input MergeInput) ([]byte, error) {
+  for _, data := range [][]byte{input.Base, input.Local, input.Remote} {
+    if bytes.IndexByte(data, 0) >= 0 {
+      return nil, errors.New("the text merge strategy can't merge binary files")
+    }
+  }
+
+  base := splitLines(string(input.Base))
+  local := splitLines(string(input.Local))
+  remote := splitLines(string(input.Remote))
+
+  toLocal := matchLines(base, local)
+  toRemote := matchLines(base, remote)
+
+  var out strings.Builder
+  conflict := false
+  i, l, r := 0, 0, 0
+  for {
+    // Lines that are unchanged in both versions
+    for i < len(base) && toLocal[i] == l && toRemote[i] == r {
+      out.WriteString(base[i])
+      i, l, r = i + 1, l + 1, r + 1
+    }
+
+    if i == len(base) && l == len(local) && r == len(remote) {
+      break
+    }
+
+    // The next base line that is still in both versions ends the changed block
+    next := i
+    for next < len(base) && (toLocal[next] < 0 || toRemote[next] < 0) {
+      next++
+    }
+    endLocal, endRemote := len(local), len(remote)
+    if next < len(base) {
+      endLocal, endRemote = toLocal[next], toRemote[next]
+    }
+
+    o := base[i:next]
+    a := local[l:endLocal]
+    b := remote[r:endRemote]
+
+    switch {
+      case slices.Equal(a, o):
+        out.WriteString(strings.Join(b, ""))
+      case slices.Equal(b, o), slices.Equal(a, b):
+        out.WriteString(strings.Join(a, ""))
+      default:
+        conflict = true
+        out.WriteString("<<<<<<< local\n")
+        writeLines(&out, a)
+        out.WriteString("=======\n")
+        writeLines(&out, b)
+        out.WriteString(">>>>>>> remote\n")
+    }
+
+    i, l, r = next, endLocal, endRemote
+  }
+
+  if conflict {
+    return []byte(out.String()), ErrMergeConflict
+  }
+
+  return []byte(out.String()), nil
+}
+
+func splitLines(text string) []string {
+  lines := strings.SplitAfter(text, "\n")
+  if lines[len(lines) - 1] == "" {
+    lines = lines[:len(lines) - 1]
+  }
+  return lines
+}
+
+// writeLines writes the lines of a conflict and makes sure the last one
+// ends with a newline, so the next marker always starts on a new line
+func writeLines(out *strings.Builder, lines []string) {
+  for _, line := range lines {
+    out.WriteString(line)
+  }
+  if len(lines) > 0 && !strings.HasSuffix(lines[len(lines) - 1], "\n") {
+    out.WriteString("\n")
+  }
+}
+
+// matchLines returns for every line of a the index of the same line in b
+// within the longest common subsequence, or -1 when the line is not in b.
+// It is Myers' O(ND) difference algorithm.
+func matchLines(a []string, b []string) []int {
+  match := make([]int, len(a))
+  for i := range match {
+    match[i] = -1
+  }
+
+  n, m := len(a), len(b)
+  offset := n + m + 1
+  v := make([]int, 2 * offset + 1)
+  trace := make([][]int, 0)
+
+  for d := 0; d <= n + m; d++ {
+    trace = append(trace, slices.Clone(v[offset - d : offset + d + 1]))
+
+    for k := -d; k <= d; k += 2 {
+      var x int
+      if k == -d || (k != d && v[offset + k - 1] < v[offset + k + 1]) {
+        x = v[offset + k + 1]
+      } else {
+        x = v[offset + k - 1] + 1
+      }
+      y := x - k
+      for x < n && y < m && a[x] == b[y] {
+        x, y = x + 1, y + 1
+      }
+      v[offset + k] = x
+
+      if x >= n && y >= m {
+        backtrack(match, trace, n, m)
+        return match
+      }
+    }
+  }
+
+  return match
+}
+
+func backtrack(match []int, trace [][]int, x int, y int) {
+  for d := len(trace) - 1; d > 0; d-- {
+    // trace[d] holds the furthest points of d - 1, indexed from -d
+    v := trace[d]
+    k := x - y
+
+    var previous int
+    if k == -d || (k != d && v[k - 1 + d] < v[k + 1 + d]) {
+      previous = k + 1
+    } else {
+      previous = k - 1
+    }
+    px := v[previous + d]
+    py := px - previous
+
+    for x > px && y > py {
+      x, y = x - 1, y - 1
+      match[x] = y
+    }
+    x, y = px, py
+  }
+
+  for x > 0 && y > 0 {
+    x, y = x - 1, y - 1
+    match[x] = y
+  }
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestTextMerge(t *testing.T) {
+  tests := []struct {
+    name string
+    base string
+    local string
+    remote string
+    want string
+    conflict bool
+  }{
+    {
+      name: "unchanged",
+      base: "a\nb\nc\n",
+      local: "a\nb\nc\n",
+      remote: "a\nb\nc\n",
+      want: "a\nb\nc\n",
+    },
+    {
+      name: "only local changed",
+      base: "a\nb\nc\n",
+      local: "a\nB\nc\n",
+      remote: "a\nb\nc\n",
+      want: "a\nB\nc\n",
+    },
+    {
+      name: "different lines changed",
+      base: "a\nb\nc\nd\ne\n",
+      local: "a\nB\nc\nd\ne\n",
+      remote: "a\nb\nc\nD\ne\n",
+      want: "a\nB\nc\nD\ne\n",
+    },
+    {
+      name: "same change on both sides",
+      base: "a\nb\nc\n",
+      local: "a\nx\nc\n",
+      remote: "a\nx\nc\n",
+      want: "a\nx\nc\n",
+    },
+    {
+      name: "insert and delete",
+      base: "a\nb\nc\nd\n",
+      local: "a\nnew\nb\nc\nd\n",
+      remote: "a\nb\nd\n",
+      want: "a\nnew\nb\nd\n",
+    },
+    {
+      name: "overlapping changes",
+      base: "a\nb\nc\n",
+      local: "a\nx\nc\n",
+      remote: "a\ny\nc\n",
+      want: "a\n<<<<<<< local\nx\n=======\ny\n>>>>>>> remote\nc\n",
+      conflict: true,
+    },
+    {
+      name: "change against delete",
+      base: "a\nb\nc\n",
+      local: "a\nx\nc\n",
+      remote: "a\nc\n",
+      want: "a\n<<<<<<< local\nx\n=======\n>>>>>>> remote\nc\n",
+      conflict: true,
+    },
+    {
+      name: "edits at start and end",
+      base: "a\nb\nc\n",
+      local: "first\na\nb\nc\n",
+      remote: "a\nb\nc\nlast\n",
+      want: "first\na\nb\nc\nlast\n",
+    },
+    {
+      name: "changed first and last line",
+      base: "a\nb\nc\n",
+      local: "A\nb\nc\n",
+      remote: "a\nb\nC\n",
+      want: "A\nb\nC\n",
+    },
+    {
+      name: "conflict at the start",
+      base: "a\nb\n",
+      local: "x\nb\n",
+      remote: "y\nb\n",
+      want: "<<<<<<< local\nx\n=======\ny\n>>>>>>> remote\nb\n",
+      conflict: true,
+    },
+    {
+      name: "conflict at the end",
+      base: "a\nb\n",
+      local: "a\nx\n",
+      remote: "a\nb\ny\n",
+      want: "a\n<<<<<<< local\nx\n=======\nb\ny\n>>>>>>> remote\n",
+      conflict: true,
+    },
+    {
+      name: "missing trailing newline kept",
+      base: "a\nb",
+      local: "A\nb",
+      remote: "a\nb",
+      want: "A\nb",
+    },
+    {
+      name: "trailing newline added on one side",
+      base: "a\nb\nc",
+      local: "A\nb\nc",
+      remote: "a\nb\nc\n",
+      want: "A\nb\nc\n",
+    },
+    {
+      name: "conflict without trailing newline",
+      base: "a\nb",
+      local: "a\nx",
+      remote: "a\ny",
+      want: "a\n<<<<<<< local\nx\n=======\ny\n>>>>>>> remote\n",
+      conflict: true,
+    },
+    {
+      name: "empty base",
+      base: "",
+      local: "a\n",
+      remote: "b\n",
+      want: "<<<<<<< local\na\n=======\nb\n>>>>>>> remote\n",
+      conflict: true,
+    },
+  }
+
+  for _, test := range tests {
+    got, err := textMerge{}.Merge(MergeInput{
+      Base: []byte(test.base),
+      Local: []byte(test.local),
+      Remote: []byte(test.remote),
+    })
+
+    if test.conflict && err != ErrMergeConflict {
+      t.Errorf("%v: error = %v, want %v", test.name, err, ErrMergeConflict)
+    } else if !test.conflict && err != nil {
+      t.Errorf("%v: error = %v", test.name, err)
+    }
+    if string(got) != test.want {
+      t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+    }
+  }
+}
+
+func TestTextMergeBinary(t *testing.T) {
+  _, err := textMerge{}.Merge(MergeInput{
+    Base: []byte("a\n"),
+    Local: []byte("a\x00\n"),
+    Remote: []byte("a\n"),
+  })
+  if err == nil {
+    t.Error("merged a binary file")
+  }
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNeedsReauth is returned when the provider no longer accepts the saved
+// credentials, i.e. because access was revoked
+var ErrNeedsReauth = errors.New("the credentials are no longer valid, run \"syncer auth\" again")
+
+// savingTokenSource writes every refreshed token back to the credential
+// store, so the next start doesn't have to refresh again.
+// When the refresh is rejected it checks if another process saved a new
+// token in the meantime, i.e. after "syncer auth" ran while the daemon was
+// running.
+type savingTokenSource struct {
+  name string
+  config *oauth2.Config
+
+  mu sync.Mutex
+  src oauth2.TokenSource
+  access string
+  refresh string
+}
+
+func newSavingTokenSource(name string, config *oauth2.Config, tok *oauth2.Token) *savingTokenSource {
+  return &savingTokenSource{
+    name: name,
+    config: config,
+    src: config.TokenSource(context.Background(), tok),
+    access: tok.AccessToken,
+    refresh: tok.RefreshToken,
+  }
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  tok, err := s.src.Token()
+  if isInvalidGrant(err) && s.reload() {
+    tok, err = s.src.Token()
+  }
+  if isRejected(err) {
+    return nil, fmt.Errorf("%w: %v", ErrNeedsReauth, err)
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  if tok.AccessToken != s.access {
+    // A token that can't be saved is still good for this run
+    if saveToken(s.name, tok) == nil {
+      s.access = tok.AccessToken
+      s.refresh = tok.RefreshToken
+    }
+  }
+
+  return tok, nil
+}
+
+// reload switches to the saved token when it differs from the one in use
+func (s *savingTokenSource) reload() bool {
+  tok, err := readToken(s.name)
+  if err != nil || tok.RefreshToken == "" || tok.RefreshToken == s.refresh {
+    return false
+  }
+
+  s.src = s.config.TokenSource(context.Background(), tok)
+  s.access = tok.AccessToken
+  s.refresh = tok.RefreshToken
+
+  return true
+}
+
+// revokeToken invalidates the token at the revocation endpoint (RFC 7009).
+// Revoking the refresh token revokes the access tokens issued with it.
+// A token the provider doesn't know anymore counts as revoked.
+func revokeToken(endpoint string, tok *oauth2.Token) error {
+  token := tok.RefreshToken
+  if token == "" {
+    token = tok.AccessToken
+  }
+
+  res, err := http.PostForm(endpoint, url.Values{"token": {token}})
+  if err != nil {
+    return err
+  }
+  defer res.Body.Close()
+
+  if res.StatusCode == http.StatusOK {
+    return nil
+  }
+
+  var body struct {
+    Error string `json:"error"`
+  }
+  json.NewDecoder(res.Body).Decode(&body)
+  if body.Error == "invalid_token" {
+    return nil
+  }
+
+  return fmt.Errorf("revocation failed: %v %v", res.Status, body.Error)
+}
+
+// rejectedTokenSource reports credentials the provider refuses as
+// ErrNeedsReauth, for sources that can't be refreshed by the user
+type rejectedTokenSource struct {
+  src oauth2.TokenSource
+}
+
+func (s rejectedTokenSource) Token() (*oauth2.Token, error) {
+  tok, err := s.src.Token()
+
+  if isRejected(err) {
+    return nil, fmt.Errorf("%w: %v", ErrNeedsReauth, err)
+  }
+
+  return tok, err
+}
+
+func isInvalidGrant(err error) bool {
+  return retrieveErrorCode(err) == "invalid_grant"
+}
+
+// isRejected tells if the provider refused the credentials, either the
+// token or the OAuth client it was issued for
+func isRejected(err error) bool {
+  switch retrieveErrorCode(err) {
+    case "invalid_grant", "invalid_client", "unauthorized_client":
+      return true
+    default:
+      return false
+  }
+}
+
+// retrieveErrorCode returns the OAuth error code of a failed token request.
+// Not every token source parses it, so it's read from the body then.
+func retrieveErrorCode(err error) string {
+  var r *oauth2.RetrieveError
+  if !errors.As(err, &r) {
+    return ""
+  }
+  if r.ErrorCode != "" {
+    return r.ErrorCode
+  }
+
+  var body struct {
+    Error string `json:"error"`
+  }
+  json.Unmarshal(r.Body, &body)
+
+  return body.Error
+}
+
+func readToken(name string) (*oauth2.Token, error) {
+  store, err := credentialStore()
+  if err != nil {
+    return nil, err
+  }
+
+  data, err := store.Load(name)
+  if err != nil {
+    return nil, err
+  }
+
+  tok := &oauth2.Token{}
+  err = json.Unmarshal(data, tok)
+  if err != nil {
+    return nil, fmt.Errorf("%w: unable to read the token %v: %v", ErrNeedsReauth, name, err)
+  }
+
+  return tok, nil
+}
+
+// saveToken replaces the saved token in the credential store
+func saveToken(name string, token *oauth2.Token) error {
+  store, err := credentialStore()
+  if err != nil {
+    return err
+  }
+
+  data, err := json.Marshal(token)
+  if err != nil {
+    return err
+  }
+
+  return store.Save(name, data)
+}
+
+// deleteToken removes the saved token from the credential store
+func deleteToken(name string) error {
+  store, err := credentialStore()
+  if err != nil {
+    return err
+  }
+
+  return store.Delete(name)
+}
@@ -0,0 +1,218 @@
+//go:build linux
+
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+  watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MODIFY | unix.IN_DELETE_SELF
+)
+
+// Watcher reports local files that have been written to.
+// The parent directory of every file is watched instead of the file itself,
+// so editors that save by writing a temporary file and renaming it over the
+// original (like KeePassXC) are picked up as well.
+// A file that is a symlink, i.e. of a dotfile manager, is written through
+// the link, so the directory of its target is watched as well.
+// Bursts of events for the same file are debounced into a single event.
+type Watcher struct {
+  Events chan string
+
+  file *os.File
+  delay time.Duration
+
+  mu sync.Mutex
+  dirs map[string]int
+  wds map[int]string
+  // paths maps the files events are reported for to the watched path, the
+  // target of a symlink maps to the link
+  paths map[string]string
+  timers map[string]*time.Timer
+  muted map[string]time.Time
+  done chan struct{}
+}
+
+func NewWatcher(delay time.Duration) (*Watcher, error) {
+  fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+  if err != nil {
+    return nil, err
+  }
+
+  w := &Watcher{
+    Events: make(chan string, 16),
+    file: os.NewFile(uintptr(fd), "inotify"),
+    delay: delay,
+    dirs: make(map[string]int),
+    wds: make(map[int]string),
+    paths: make(map[string]string),
+    timers: make(map[string]*time.Timer),
+    muted: make(map[string]time.Time),
+    done: make(chan struct{}),
+  }
+
+  go w.read()
+
+  return w, nil
+}
+
+// Watch replaces the set of watched files with the given paths.
+// Directories that do not exist (yet) are skipped, calling Watch again
+// later picks them up.
+func (w *Watcher) Watch(paths []string) error {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  w.paths = make(map[string]string)
+  wanted := make(map[string]bool)
+  for _, p := range paths {
+    p = filepath.Clean(p)
+    w.paths[p] = p
+    wanted[filepath.Dir(p)] = true
+
+    target, err := resolveSymlinks(p)
+    if err == nil && target != p {
+      w.paths[target] = p
+      wanted[filepath.Dir(target)] = true
+    }
+  }
+
+  for dir, wd := range w.dirs {
+    if wanted[dir] {
+      continue
+    }
+    unix.InotifyRmWatch(int(w.file.Fd()), uint32(wd))
+    delete(w.dirs, dir)
+    delete(w.wds, wd)
+  }
+
+  var errs error
+  for dir := range wanted {
+    if _, ok := w.dirs[dir]; ok {
+      continue
+    }
+    wd, err := unix.InotifyAddWatch(int(w.file.Fd()), dir, watchMask)
+    if err != nil {
+      if !os.IsNotExist(err) {
+        errs = err
+      }
+      continue
+    }
+    w.dirs[dir] = wd
+    w.wds[wd] = dir
+  }
+
+  return errs
+}
+
+// Mute drops all events for path until Unmute is called.
+// This is used to ignore the writes of our own pulls.
+func (w *Watcher) Mute(path string) {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  path = filepath.Clean(path)
+  w.muted[path] = time.Time{}
+  if t, ok := w.timers[path]; ok {
+    t.Stop()
+    delete(w.timers, path)
+  }
+}
+
+// Unmute starts reporting events for path again, after a short grace period
+// for events that are still on their way.
+func (w *Watcher) Unmute(path string) {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  w.muted[filepath.Clean(path)] = time.Now().Add(w.delay)
+}
+
+func (w *Watcher) Close() error {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  select {
+  case <-w.done:
+    return nil
+  default:
+  }
+
+  close(w.done)
+  for _, t := range w.timers {
+    t.Stop()
+  }
+
+  return w.file.Close()
+}
+
+func (w *Watcher) read() {
+  buffer := make([]byte, 64 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1))
+
+  for {
+    n, err := w.file.Read(buffer)
+    if err != nil {
+      return
+    }
+
+    for offset := 0; offset + unix.SizeofInotifyEvent <= n; {
+      event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+      name := buffer[offset + unix.SizeofInotifyEvent : offset + unix.SizeofInotifyEvent + int(event.Len)]
+      offset += unix.SizeofInotifyEvent + int(event.Len)
+
+      w.handle(int(event.Wd), event.Mask, string(bytes.TrimRight(name, "\x00")))
+    }
+  }
+}
+
+func (w *Watcher) handle(wd int, mask uint32, name string) {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+
+  dir, ok := w.wds[wd]
+  if !ok {
+    return
+  }
+
+  if mask & (unix.IN_DELETE_SELF | unix.IN_IGNORED) != 0 {
+    delete(w.wds, wd)
+    delete(w.dirs, dir)
+    return
+  }
+
+  path, ok := w.paths[filepath.Join(dir, name)]
+  if !ok {
+    return
+  }
+
+  if until, ok := w.muted[path]; ok {
+    if until.IsZero() || time.Now().Before(until) {
+      return
+    }
+    delete(w.muted, path)
+  }
+
+  if t, ok := w.timers[path]; ok {
+    t.Reset(w.delay)
+    return
+  }
+
+  w.timers[path] = time.AfterFunc(w.delay, func() {
+    w.mu.Lock()
+    delete(w.timers, path)
+    w.mu.Unlock()
+
+    select {
+    case w.Events <- path:
+    case <-w.done:
+    }
+  })
+}
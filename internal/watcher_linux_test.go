@@ -0,0 +1,67 @@
+//go:build linux
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherSymlink(t *testing.T) {
+  dir := t.TempDir()
+  target := filepath.Join(dir, "dotfiles", "config")
+  link := filepath.Join(dir, "home", "config")
+
+  for _, d := range []string{filepath.Dir(target), filepath.Dir(link)} {
+    err := os.MkdirAll(d, 0700)
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+  err := os.WriteFile(target, []byte("old"), 0600)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = os.Symlink(target, link)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  w, err := NewWatcher(10 * time.Millisecond)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer w.Close()
+
+  err = w.Watch([]string{link})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    name string
+    write func() error
+  }{
+    {"write through the link", func() error { return os.WriteFile(link, []byte("link"), 0600) }},
+    {"write to the target", func() error { return os.WriteFile(target, []byte("target"), 0600) }},
+    {"replace the target", func() error { return writeFileAtomic(link, []byte("atomic")) }},
+  }
+
+  for _, test := range tests {
+    err = test.write()
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    select {
+    case path := <-w.Events:
+      if path != link {
+        t.Errorf("%v: event for %v, want %v", test.name, path, link)
+      }
+    case <-time.After(time.Second):
+      t.Errorf("%v: no event", test.name)
+    }
+  }
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+// Watcher is only implemented on linux, other platforms fall back to polling.
+type Watcher struct {
+  Events chan string
+}
+
+func NewWatcher(delay time.Duration) (*Watcher, error) {
+  return nil, errors.New("watching local files is not supported on this platform")
+}
+
+func (w *Watcher) Watch(paths []string) error {
+  return nil
+}
+
+func (w *Watcher) Mute(path string) {}
+
+func (w *Watcher) Unmute(path string) {}
+
+func (w *Watcher) Close() error {
+  return nil
+}